@@ -4,7 +4,13 @@ package bro
 // #include <stdlib.h>
 // #include <brotli/decode.h>
 import "C"
-import "unsafe"
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"runtime"
+	"unsafe"
+)
 
 type BrotliDecoder struct {
 	bro *C.struct_BrotliDecoderStateStruct
@@ -58,3 +64,116 @@ func (b BrotliDecoder) Decompress(in []byte) []byte {
 		return nil
 	}
 }
+
+type Reader struct {
+	r                       io.Reader
+	bro                     *C.struct_BrotliDecoderStateStruct
+	dict                    *C.uint8_t
+	readBuffer, writeBuffer *C.uint8_t
+	nextIn                  *C.uint8_t
+	availIn                 C.size_t
+	needInput               bool
+	out                     []byte
+	err                     error
+	tmpBuff                 [kFileBufferSize]byte
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderDict(r, nil)
+}
+
+func NewReaderDict(r io.Reader, dict []byte) (*Reader, error) {
+	var br Reader
+
+	br.bro = C.BrotliDecoderCreateInstance(nil, nil, nil)
+	br.readBuffer = (*C.uint8_t)(C.malloc(C.size_t(kFileBufferSize)))
+	br.writeBuffer = (*C.uint8_t)(C.malloc(C.size_t(kFileBufferSize)))
+	if len(dict) != 0 {
+		br.dict = (*C.uint8_t)(C.malloc(C.size_t(len(dict))))
+	}
+
+	if br.bro == nil || br.readBuffer == nil || br.writeBuffer == nil || (len(dict) != 0 && br.dict == nil) {
+		br.free()
+		return nil, flate.InternalError("cgo allocation failed")
+	}
+
+	/* The decoder does not copy the dictionary, it must live as long as the state */
+	if len(dict) != 0 {
+		C.memcpy(unsafe.Pointer(br.dict), unsafe.Pointer(&dict[0]), C.size_t(len(dict)))
+		C.BrotliDecoderSetCustomDictionary(br.bro, C.size_t(len(dict)), br.dict)
+	}
+
+	br.r = r
+	br.needInput = true
+
+	runtime.SetFinalizer(&br, func(br *Reader) {
+		br.free()
+	})
+
+	return &br, nil
+}
+
+func (r *Reader) free() {
+	if r.bro != nil {
+		C.BrotliDecoderDestroyInstance(r.bro)
+	}
+	C.free(unsafe.Pointer(r.readBuffer))
+	C.free(unsafe.Pointer(r.writeBuffer))
+	C.free(unsafe.Pointer(r.dict))
+	r.bro, r.readBuffer, r.writeBuffer, r.dict = nil, nil, nil, nil
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		if r.needInput && r.availIn == 0 {
+			m, e := r.r.Read(r.tmpBuff[:])
+			if m == 0 {
+				if e == io.EOF {
+					e = io.ErrUnexpectedEOF
+				}
+				r.err = e
+				continue
+			}
+
+			C.memcpy(unsafe.Pointer(r.readBuffer), unsafe.Pointer(&r.tmpBuff[0]), C.size_t(m))
+			r.nextIn = r.readBuffer
+			r.availIn = C.size_t(m)
+		}
+
+		availOut := C.size_t(kFileBufferSize)
+		nextOut := r.writeBuffer
+
+		result := C.BrotliDecoderDecompressStream(r.bro, &r.availIn, &r.nextIn, &availOut, &nextOut, nil)
+
+		if outSize := kFileBufferSize - int(availOut); outSize != 0 {
+			r.out = C.GoBytes(unsafe.Pointer(r.writeBuffer), C.int(outSize))
+		}
+
+		r.needInput = false
+		switch result {
+		case C.BROTLI_DECODER_RESULT_SUCCESS:
+			r.err = io.EOF
+		case C.BROTLI_DECODER_RESULT_NEEDS_MORE_INPUT:
+			r.needInput = true
+		case C.BROTLI_DECODER_RESULT_NEEDS_MORE_OUTPUT:
+		default:
+			code := C.BrotliDecoderGetErrorCode(r.bro)
+			r.err = fmt.Errorf("brotli: failed to decompress data: %s", C.GoString(C.BrotliDecoderErrorString(code)))
+		}
+	}
+
+	n = copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *Reader) Close() error {
+	runtime.SetFinalizer(r, nil)
+	r.free()
+	r.err = fmt.Errorf("brotli: reader is closed")
+	return nil
+}
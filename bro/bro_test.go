@@ -2,7 +2,9 @@ package bro
 
 import (
 	"bytes"
+	"io/ioutil"
 	"log"
+	"strings"
 	"testing"
 )
 
@@ -21,7 +23,7 @@ func TestBrotli(t *testing.T) {
 		dec := Decoder()
 
 		if v.dict != "" {
-			enc.SetDict([]byte(v.dict))
+			enc.SetDict([]byte(v.dict), 11)
 			dec.SetDict([]byte(v.dict))
 		}
 
@@ -34,3 +36,45 @@ func TestBrotli(t *testing.T) {
 		}
 	}
 }
+
+func TestBrotliReader(t *testing.T) {
+	tests := append(brotliTests, struct{ dict, in string }{dict: "", in: strings.Repeat("X", 10<<20)})
+
+	for _, v := range tests {
+		enc := Encoder()
+
+		if v.dict != "" {
+			enc.SetDict([]byte(v.dict), 11)
+		}
+
+		comp := enc.Compress(11, []byte(v.in))
+
+		r, err := NewReaderDict(bytes.NewReader(comp), []byte(v.dict))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decomp, err := ioutil.ReadAll(r)
+		r.Close()
+
+		if err != nil {
+			t.Errorf("Error in test %q: %v", v.dict, err)
+		} else if !bytes.Equal(decomp, []byte(v.in)) {
+			t.Errorf("Mismatch in test %q", v.dict)
+		}
+	}
+}
+
+func TestBrotliReaderTruncated(t *testing.T) {
+	comp := Encoder().Compress(11, []byte("XXXXXXXXXXYYYYYYYYYYZZZZZZZZZZ"))
+
+	r, err := NewReader(bytes.NewReader(comp[:len(comp)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("Expected error for truncated stream")
+	}
+}
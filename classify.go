@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+/* classifyLen is how much of a body the classifier looks at */
+const classifyLen = 4096
+
+/* classTypes is the canonical content type of every class the classifier detects */
+var classTypes = map[string]string{
+	"html":  "text/html",
+	"css":   "text/css",
+	"js":    "text/javascript",
+	"json":  "application/json",
+	"svg":   "image/svg+xml",
+	"xml":   "application/xml",
+	"woff":  "font/woff",
+	"woff2": "font/woff2",
+	"ttf":   "font/ttf",
+	"otf":   "font/otf",
+}
+
+var fontMagic = []struct {
+	magic string
+	class string
+}{
+	{"wOFF", "woff"},
+	{"wOF2", "woff2"},
+	{"\x00\x01\x00\x00", "ttf"},
+	{"true", "ttf"},
+	{"OTTO", "otf"},
+}
+
+var htmlTags = regexp.MustCompile(`(?i)<(!doctype\s+html|html|head|body|meta|title|div|script|link|p|a|span|table|ul|form)[\s>/]`)
+var cssTokens = regexp.MustCompile(`@(media|import|font-face|charset|keyframes|supports)\b|[\w\]\)*-]\s*\{\s*[\w-]+\s*:[^;{}]*[;}]`)
+var jsTokens = regexp.MustCompile(`\b(function|var|let|const|return|typeof|undefined|window|document|this|new|null)\b|=>|===|!==|&&|\|\|`)
+
+/* jsSyntax is the code structure prose and robots.txt lack, whatever keywords they contain: arrows, functions, assignments, blocks and statements */
+var jsSyntax = regexp.MustCompile(`(?m)=>|\bfunction\s*[\w$]*\s*\(|\b(var|let|const)\s+[\w$]+\s*=|[\w$\])]\s+=\s+[^=\s]|\{[^{}]*\}|[\w$)\]'"]\s*;\s*$|\);`)
+
+/* compressedClasses are already compressed, no dictionary helps them */
+var compressedClasses = map[string]bool{"woff": true, "woff2": true}
+
+/* classifyBody tells what a body is from its first bytes, whatever the server declared; the class is empty if unknown */
+func classifyBody(body []byte) (class string, minified bool) {
+	/* Fonts by their magic number, markup by its tags, JSON by its start, CSS and JS by their tokens */
+	for _, f := range fontMagic {
+		if bytes.HasPrefix(body, []byte(f.magic)) {
+			return f.class, false
+		}
+	}
+
+	head := body
+	if len(head) > classifyLen {
+		head = head[:classifyLen]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "", false
+	}
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	text := bytes.TrimSpace(head)
+	if len(text) == 0 {
+		return "", false
+	}
+
+	switch {
+	case text[0] == '<':
+		lower := strings.ToLower(string(text))
+		switch {
+		case strings.Contains(lower, "<svg"):
+			return "svg", false
+		case htmlTags.MatchString(lower):
+			return "html", false
+		case strings.HasPrefix(lower, "<?xml"):
+			return "xml", false
+		}
+		return "", false
+	case jsonStart.Match(text):
+		return "json", isMinified(head)
+	}
+
+	css := len(cssTokens.FindAllIndex(text, -1))
+	js := len(jsTokens.FindAllIndex(text, -1))
+	switch {
+	case css > 0 && css >= js:
+		return "css", isMinified(head)
+	case js > 1 && jsSyntax.Match(text):
+		return "js", isMinified(head)
+	}
+
+	return "", false
+}
+
+/* isMinified is true for code with an average line longer than 200 characters */
+func isMinified(b []byte) bool {
+	if len(b) < 512 {
+		return false
+	}
+	return len(b)/(bytes.Count(b, []byte("\n"))+1) > 200
+}
+
+/* assetDictType is the dictionary type of an asset: by default from its declared type, or from its detected class with -type-source detected */
+func assetDictType(declared string, e *manifestEntry, body []byte) string {
+	if *typeSource == "detected" {
+		var class string
+		if e != nil {
+			class = e.Class
+		}
+		if class == "" && body != nil {
+			class, _ = classifyBody(body)
+		}
+		if ct, ok := classTypes[class]; ok {
+			return groupType(ct)
+		}
+	}
+	return dictType(declared, body)
+}
+
+/* acceptAsset keeps an asset if its declared type is one we want, or its body looks like one */
+func acceptAsset(contentType string, body []byte) bool {
+	if isAcceptedContent(contentType) {
+		return true
+	}
+	class, _ := classifyBody(body)
+	return class != "" && !compressedClasses[class]
+}
+
+/* mayAccept tells if an asset is worth downloading before its body is known: only if its declared type says nothing */
+func mayAccept(contentType string) bool {
+	return isAcceptedContent(contentType) || genericTypes[strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))]
+}
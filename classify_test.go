@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClassifyBody(t *testing.T) {
+	minifiedJS := strings.Repeat("var a=function(b){return b===null?void 0:b.c&&b.d};", 20)
+
+	tests := []struct {
+		body     string
+		class    string
+		minified bool
+	}{
+		{"<!DOCTYPE html><html><head></head></html>", "html", false},
+		{"\n  <div class=\"a\">b</div>", "html", false},
+		{`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`, "svg", false},
+		{`<?xml version="1.0"?><rss></rss>`, "xml", false},
+		{`{"a": [1, 2]}`, "json", false},
+		{"body {\n  margin: 0;\n}\n@media print { a { color: red } }", "css", false},
+		{"function f(a) {\n  return a === undefined;\n}", "js", false},
+		{minifiedJS, "js", true},
+		{"wOF2\x00\x01\x00\x00", "woff2", false},
+		{"OTTO\x00\x0a", "otf", false},
+		{"just some text", "", false},
+		{"This new window will return the document to you. Then this function is null and void, || so they say.", "", false},
+		{"User-agent: *\nDisallow: /search?q=new&window=this\nAllow: /document/\nSitemap: https://example.com/sitemap.xml\n", "", false},
+		{"const f = (a) => a;\nlet b = new f(this)", "js", false},
+		{"\x89PNG\r\n\x1a\n\x00\x00", "", false},
+	}
+
+	for _, test := range tests {
+		class, minified := classifyBody([]byte(test.body))
+		if class != test.class || minified != test.minified {
+			t.Errorf("%.20q: got %s %v, want %s %v", test.body, class, minified, test.class, test.minified)
+		}
+	}
+}
+
+func TestAcceptAsset(t *testing.T) {
+	if !mayAccept("") || !mayAccept("application/octet-stream") || mayAccept("image/png") {
+		t.Errorf("mayAccept should only let generic types through")
+	}
+	if !acceptAsset("", []byte("function f() { return this; }")) {
+		t.Errorf("Undeclared javascript should be accepted")
+	}
+	if acceptAsset("application/octet-stream", []byte("\x89PNG\r\n\x1a\n")) {
+		t.Errorf("Undeclared image should be rejected")
+	}
+	if acceptAsset("", []byte("wOF2\x00\x01\x00\x00")) || acceptAsset("application/octet-stream", []byte("wOFF\x00\x01\x00\x00")) {
+		t.Errorf("Undeclared woff and woff2 fonts are already compressed and should be rejected")
+	}
+	if !acceptAsset("", []byte("OTTO\x00\x0a")) {
+		t.Errorf("Undeclared otf fonts should be accepted")
+	}
+	if acceptAsset("application/octet-stream", []byte("User-agent: *\nDisallow: /this/new/window\nAllow: /document/\n")) {
+		t.Errorf("Undeclared robots.txt should not pass for javascript")
+	}
+}
+
+func TestDetectedDictType(t *testing.T) {
+	body := []byte("function f(a) {\n  return a === undefined;\n}")
+	if got := assetDictType("text/html", nil, body); got != "text/html" {
+		t.Errorf("declared: got %s", got)
+	}
+
+	*typeSource = "detected"
+	defer func() { *typeSource = "declared" }()
+	if got := assetDictType("text/html", nil, body); got != "text/javascript" {
+		t.Errorf("detected: got %s", got)
+	}
+	if got := assetDictType("text/html", &manifestEntry{Class: "css"}, body); got != "text/css" {
+		t.Errorf("detected from manifest: got %s", got)
+	}
+	if got := assetDictType("text/plain", nil, []byte("just some text")); got != "text/plain" {
+		t.Errorf("undetected: got %s", got)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const sniffLen = 512
+
+/* typeAliases maps the many names servers use for the same kind of content to one */
+var typeAliases = map[string]string{
+	"application/javascript":      "text/javascript",
+	"application/x-javascript":    "text/javascript",
+	"application/ecmascript":      "text/javascript",
+	"application/x-ecmascript":    "text/javascript",
+	"text/ecmascript":             "text/javascript",
+	"text/x-javascript":           "text/javascript",
+	"text/js":                     "text/javascript",
+	"text/jscript":                "text/javascript",
+	"text/json":                   "application/json",
+	"application/x-json":          "application/json",
+	"text/x-json":                 "application/json",
+	"text/xml":                    "application/xml",
+	"application/xml+rss":         "application/rss+xml",
+	"text/x-markdown":             "text/markdown",
+	"image/svg":                   "image/svg+xml",
+	"application/font-woff":       "font/woff",
+	"application/x-font-woff":     "font/woff",
+	"font/x-woff":                 "font/woff",
+	"application/font-woff2":      "font/woff2",
+	"font/x-woff2":                "font/woff2",
+	"application/ttf":             "font/ttf",
+	"application/x-ttf":           "font/ttf",
+	"application/x-font-ttf":      "font/ttf",
+	"application/x-font-truetype": "font/ttf",
+	"application/truetype":        "font/ttf",
+	"application/font-sfnt":       "font/ttf",
+	"font/sfnt":                   "font/ttf",
+	"font/truetype":               "font/ttf",
+	"application/otf":             "font/otf",
+	"application/x-otf":           "font/otf",
+	"application/x-font-otf":      "font/otf",
+	"application/opentype":        "font/otf",
+	"application/x-opentype":      "font/otf",
+	"font/opentype":               "font/otf",
+	"application/eot":             "application/vnd.ms-fontobject",
+	"application/x-font-eot":      "application/vnd.ms-fontobject",
+}
+
+/* genericTypes say nothing about the body, it is sniffed instead */
+var genericTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/unknown":      true,
+	"application/x-unknown":    true,
+	"unknown/unknown":          true,
+	"text/plain":               true,
+}
+
+/* typeGroups merges canonical types that should share a dictionary, from -type-groups */
+var typeGroups = map[string]string{}
+
+/* loadTypeGroups reads a JSON object mapping canonical types to the name of their group */
+func loadTypeGroups(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &typeGroups); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func isBinaryType(ct string) bool {
+	return strings.HasPrefix(ct, "font/") || (strings.HasPrefix(ct, "image/") && ct != "image/svg+xml") ||
+		ct == "application/vnd.ms-fontobject" || ct == "application/pdf" || ct == "application/zip"
+}
+
+var jsonStart = regexp.MustCompile(`^\s*(\{\s*["}]|\[\s*([\[{"\]0-9-]|true|false|null))`)
+
+/* sniffType guesses the type of a body from its first 512 bytes, like browsers do, empty if it can't tell */
+func sniffType(body []byte) string {
+	if len(body) > sniffLen {
+		body = body[:sniffLen]
+	}
+	ct := strings.Split(http.DetectContentType(body), ";")[0]
+
+	switch ct {
+	case "text/xml":
+		if bytes.Contains(body, []byte("<svg")) {
+			return "image/svg+xml"
+		}
+		return "application/xml"
+	case "text/plain":
+		if jsonStart.Match(body) {
+			return "application/json"
+		}
+		return ""
+	case "application/octet-stream":
+		return ""
+	}
+
+	return ct
+}
+
+/* canonicalType strips the parameters of a declared type and resolves its aliases; the body, when given, overrides a generic or lying declaration */
+func canonicalType(declared string, body []byte) string {
+	ct := strings.TrimSpace(strings.Split(declared, ";")[0])
+	if *typeGrouping == "raw" {
+		return ct
+	}
+
+	ct = strings.ToLower(ct)
+	if alias, ok := typeAliases[ct]; ok {
+		ct = alias
+	}
+
+	if *sniffTypes && len(body) > 0 {
+		if sniffed := sniffType(body); sniffed != "" && (genericTypes[ct] || (isBinaryType(sniffed) && !isBinaryType(ct))) {
+			ct = sniffed
+		}
+	}
+
+	return ct
+}
+
+func groupType(ct string) string {
+	if group, ok := typeGroups[ct]; ok {
+		return group
+	}
+	return ct
+}
+
+/* dictType is the key dictionaries are trained and looked up by */
+func dictType(declared string, body []byte) string {
+	return groupType(canonicalType(declared, body))
+}
+
+/* dictType of an asset, its content must be loaded for sniffing */
+func (u *asset) dictType() string {
+	if u.group != "" {
+		return u.group
+	}
+	return assetDictType(u.contentType, u.info, u.content)
+}
+
+/* readHead reads the first n bytes of a file, enough to sniff its type */
+func readHead(path string, n int) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	head := make([]byte, n)
+	n, err = io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil
+	}
+	return head[:n]
+}
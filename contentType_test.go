@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDictType(t *testing.T) {
+	woff2 := append([]byte("wOF2"), make([]byte, 60)...)
+
+	tests := []struct {
+		declared string
+		body     string
+		want     string
+	}{
+		{"application/x-javascript", "", "text/javascript"},
+		{"text/JS; charset=UTF-8", "var a = 1;", "text/javascript"},
+		{"Application/JavaScript", "", "text/javascript"},
+		{"text/html; charset=utf-8", "<!DOCTYPE html><html></html>", "text/html"},
+		{"", "<!DOCTYPE html><html></html>", "text/html"},
+		{"application/octet-stream", `{"a": [1, 2]}`, "application/json"},
+		{"text/plain", "[1, 2, 3]", "application/json"},
+		{"text/plain", "just some text", "text/plain"},
+		{"text/xml", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`, "application/xml"},
+		{"", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`, "image/svg+xml"},
+		{"text/html", string(woff2), "font/woff2"},
+		{"application/font-woff", "", "font/woff"},
+	}
+
+	for _, test := range tests {
+		if got := dictType(test.declared, []byte(test.body)); got != test.want {
+			t.Errorf("%q with %.20q: got %s, want %s", test.declared, test.body, got, test.want)
+		}
+	}
+
+	typeGroups = map[string]string{"text/javascript": "script", "application/json": "script"}
+	defer func() { typeGroups = map[string]string{} }()
+	if got := dictType("application/x-json", nil); got != "script" {
+		t.Errorf("grouped application/x-json: got %s, want script", got)
+	}
+
+	*typeGrouping = "raw"
+	defer func() { *typeGrouping = "" }()
+	if got := dictType("Application/X-Javascript; charset=utf-8", []byte("var a;")); got != "Application/X-Javascript" {
+		t.Errorf("raw: got %s", got)
+	}
+}
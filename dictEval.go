@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	evalMinMatch = 4
+	evalMaxMatch = 258
+	evalMaxChain = 64
+	evalHashBits = 16
+)
+
+/* dictMatches runs a greedy LZ77 parse of in, with dict as history, and calls visit for every match that starts in dict */
+func dictMatches(dict, in []byte, visit func(pos, length int)) {
+	buf := append(dict[:len(dict):len(dict)], in...)
+	head := make([]int32, 1<<evalHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(buf))
+
+	hash := func(i int) uint32 {
+		return (binary.LittleEndian.Uint32(buf[i:]) * 2654435761) >> (32 - evalHashBits)
+	}
+	insert := func(i int) {
+		if i+evalMinMatch <= len(buf) {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	for i := 0; i < len(dict); i++ {
+		insert(i)
+	}
+
+	for i := len(dict); i+evalMinMatch <= len(buf); {
+		bestPos, bestLen := -1, 0
+		limit := len(buf) - i
+		if limit > evalMaxMatch {
+			limit = evalMaxMatch
+		}
+
+		for j, chain := head[hash(i)], 0; j >= 0 && chain < evalMaxChain; j, chain = prev[j], chain+1 {
+			n := 0
+			for n < limit && buf[int(j)+n] == buf[i+n] {
+				n++
+			}
+			if n > bestLen {
+				bestPos, bestLen = int(j), n
+			}
+		}
+
+		if bestLen < evalMinMatch {
+			insert(i)
+			i++
+			continue
+		}
+
+		if bestPos < len(dict) {
+			length := bestLen
+			if bestPos+length > len(dict) {
+				length = len(dict) - bestPos
+			}
+			visit(bestPos, length)
+		}
+		for k := i; k < i+bestLen; k++ {
+			insert(k)
+		}
+		i += bestLen
+	}
+}
+
+/* evalAssets reads the assets of the given dictionary type from the sites */
+func evalAssets(dirs []os.FileInfo, group string) []*asset {
+	ret := make([]*asset, 0)
+
+	for _, d := range dirs {
+		for _, m := range parseManifest(datapath + d.Name() + "/manifest") {
+			content, err := ioutil.ReadFile(datapath + d.Name() + "/" + strconv.Itoa(m.idx))
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			m.content = content
+			if m.dictType() == group {
+				ret = append(ret, m)
+			}
+		}
+	}
+
+	return ret
+}
+
+/* quote shows a dictionary fragment on a single line */
+func quote(b []byte, max int) string {
+	if len(b) > max {
+		return strconv.Quote(string(b[:max])) + "..."
+	}
+	return strconv.Quote(string(b))
+}
+
+type substringStat struct {
+	pos, length int
+	matches     int
+}
+
+/* evalDictionary reports how well a dictionary does on the dataset assets of its content type, without running the whole grid */
+func evalDictionary(path string, dirs []os.FileInfo) {
+	dict, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	contentType := *evalType
+	if contentType == "" {
+		meta, err := readDictMeta(path + ".json")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		contentType = meta.ContentType
+	}
+	contentType = dictType(contentType, nil)
+
+	assets := evalAssets(dirs, contentType)
+	fmt.Printf("Dictionary %s: %d bytes, %d %s assets from %d sites\n", path, len(dict), len(assets), contentType, len(dirs))
+	if len(assets) == 0 || len(dict) == 0 {
+		return
+	}
+
+	original := 0
+	for _, u := range assets {
+		original += len(u.content)
+	}
+
+	fmt.Printf("\n%-10s %8s %14s %14s %10s %10s\n", "", "quality", "without", "with", "ratio", "dict ratio")
+	for _, c := range []compressor{&gzipper{}, &brotler{}} {
+		quality := DeflateCompressionLevel
+		if _, ok := c.(*brotler); ok {
+			quality = BrotliCompressionLevel
+		}
+
+		without, with := 0, 0
+		for _, u := range assets {
+			without += len(c.CompressWithDict(u.content, nil, quality))
+			with += len(c.CompressWithDict(u.content, dict, quality))
+		}
+		fmt.Printf("%-10s %8d %14d %14d %10.3f %10.3f\n", c, quality, without, with,
+			float64(original)/float64(without), float64(original)/float64(with))
+	}
+
+	/* Coverage comes from our own LZ77 parse, which finds the matches deflate and brotli can use */
+	referenced := make([]int, len(dict))
+	substrings := make(map[[2]int]*substringStat)
+	for _, u := range assets {
+		dictMatches(dict, u.content, func(pos, length int) {
+			for i := pos; i < pos+length; i++ {
+				referenced[i]++
+			}
+			key := [2]int{pos, length}
+			if substrings[key] == nil {
+				substrings[key] = &substringStat{pos: pos, length: length}
+			}
+			substrings[key].matches++
+		})
+	}
+
+	used := 0
+	for _, n := range referenced {
+		if n > 0 {
+			used++
+		}
+	}
+	fmt.Printf("\nReferenced: %d of %d bytes (%.1f%%)\n", used, len(dict), 100*float64(used)/float64(len(dict)))
+
+	top := make([]*substringStat, 0, len(substrings))
+	for _, s := range substrings {
+		top = append(top, s)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		a, b := top[i].matches*top[i].length, top[j].matches*top[j].length
+		if a != b {
+			return a > b
+		}
+		return top[i].pos < top[j].pos
+	})
+	if len(top) > *evalTop {
+		top = top[:*evalTop]
+	}
+
+	fmt.Printf("\nTop substrings by matched bytes:\n%8s %8s %8s %10s  %s\n", "offset", "length", "matches", "bytes", "substring")
+	for _, s := range top {
+		fmt.Printf("%8d %8d %8d %10d  %s\n", s.pos, s.length, s.matches, s.matches*s.length, quote(dict[s.pos:s.pos+s.length], 60))
+	}
+
+	fmt.Printf("\nDead regions (at least %d bytes never referenced):\n", *evalDead)
+	dead := 0
+	for start := 0; start < len(dict); {
+		if referenced[start] > 0 {
+			start++
+			continue
+		}
+		end := start
+		for end < len(dict) && referenced[end] == 0 {
+			end++
+		}
+		if end-start >= *evalDead {
+			fmt.Printf("%8d %8d  %s\n", start, end-start, quote(dict[start:end], 60))
+			dead += end - start
+		}
+		start = end
+	}
+	fmt.Printf("Dead: %d bytes (%.1f%%)\n", dead, 100*float64(dead)/float64(len(dict)))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDictMatches(t *testing.T) {
+	dict := []byte("unused-prefix-0123456789<div class=\"navigation\">")
+	in := []byte("<p>hello</p><div class=\"navigation\"><p>hello</p>")
+
+	referenced := make([]bool, len(dict))
+	dictMatches(dict, in, func(pos, length int) {
+		if pos < 0 || pos+length > len(dict) {
+			t.Fatalf("match at %d of %d bytes is outside the dictionary", pos, length)
+		}
+		for i := pos; i < pos+length; i++ {
+			referenced[i] = true
+		}
+	})
+
+	start := bytes.Index(dict, []byte("<div"))
+	for i, r := range referenced {
+		if want := i >= start; r != want {
+			t.Errorf("byte %d (%q): referenced %v, want %v", i, dict[i], r, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* dictMeta describes a dictionary, saved next to it as <dictionary>.json so the dictionary itself stays raw bytes any tool can use */
+type dictMeta struct {
+	ContentType string `json:"content_type"`
+	/* Several versions of a type may coexist, the start of their SHA-256 unless -dict-version names them */
+	Version    string            `json:"version"`
+	Size       int               `json:"size"`
+	TargetSize int               `json:"target_size"`
+	Trainer    string            `json:"trainer"`
+	Params     map[string]string `json:"params,omitempty"`
+	/* The training set, hashed from the SHA-256 of its files in sorted order */
+	TrainingSHA256 string    `json:"training_sha256"`
+	Sites          int       `json:"sites"`
+	Samples        int       `json:"samples"`
+	Created        time.Time `json:"created"`
+	SHA256         string    `json:"sha256"`
+}
+
+/* trainingHash identifies a training set, whatever the order of its files */
+func trainingHash(paths []string) (string, error) {
+	sums := make([]string, 0, len(paths))
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(content)
+		sums = append(sums, hex.EncodeToString(sum[:]))
+	}
+	sort.Strings(sums)
+
+	sum := sha256.Sum256([]byte(strings.Join(sums, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+/* writeDict saves a dictionary and its metadata in dir, under a name of its own so earlier versions are kept */
+func writeDict(dir, suffix string, meta *dictMeta, dict []byte) error {
+	sum := sha256.Sum256(dict)
+	meta.SHA256 = hex.EncodeToString(sum[:])
+	meta.Size = len(dict)
+	meta.Created = time.Now().UTC()
+	meta.Version = *dictVersion
+	if meta.Version == "" {
+		meta.Version = meta.SHA256[:12]
+	}
+
+	out, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	path := dir + strings.Replace(meta.ContentType, "/", "__", -1) + "." + meta.Version + suffix
+	if err := ioutil.WriteFile(path, dict, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".json", out, 0644)
+}
+
+func readDictMeta(path string) (*dictMeta, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta dictMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &meta, nil
+}
+
+/* readDict reads a dictionary and checks it against its metadata */
+func readDict(path string) ([]byte, *dictMeta, error) {
+	meta, err := readDictMeta(path + ".json")
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.ContentType == "" {
+		return nil, nil, fmt.Errorf("%s: no content type", path)
+	}
+
+	dict, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dict) != meta.Size {
+		return nil, nil, fmt.Errorf("%s: %d bytes, metadata says %d", path, len(dict), meta.Size)
+	}
+	if sum := sha256.Sum256(dict); hex.EncodeToString(sum[:]) != meta.SHA256 {
+		return nil, nil, fmt.Errorf("%s: SHA-256 mismatch", path)
+	}
+
+	return dict, meta, nil
+}
+
+/* loadDicts reads the valid dictionaries of dir with the given suffix: the -dict-version one of each type, or the newest */
+func loadDicts(dir, suffix string) map[string][]byte {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Println(err)
+		return map[string][]byte{}
+	}
+
+	dicts := make(map[string][]byte)
+	metas := make(map[string]*dictMeta)
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), suffix) {
+			continue
+		}
+
+		dict, meta, err := readDict(dir + f.Name())
+		if err != nil {
+			log.Println("Skipping dictionary:", err)
+			continue
+		}
+		if *dictVersion != "" && meta.Version != *dictVersion {
+			continue
+		}
+
+		ct := dictType(meta.ContentType, nil)
+		if old, ok := metas[ct]; ok {
+			if *dictVersion != "" {
+				log.Println("Skipping dictionary:", f.Name(), "has the same version as another", ct, "dictionary")
+				continue
+			}
+			if meta.Created.Before(old.Created) || (meta.Created.Equal(old.Created) && meta.Version < old.Version) {
+				continue
+			}
+		}
+
+		dicts[ct] = dict[:len(dict):len(dict)]
+		metas[ct] = meta
+	}
+
+	return dicts
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDictVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir += "/"
+	defer func() { *dictVersion = "" }()
+
+	for _, v := range []string{"v1", "v2"} {
+		*dictVersion = v
+		meta := &dictMeta{ContentType: "text/html", Trainer: "freq"}
+		if err := writeDict(dir, ".dict", meta, []byte("<html> "+v)); err != nil {
+			t.Fatal(err)
+		}
+		if meta.Size != 9 || len(meta.SHA256) != 64 {
+			t.Errorf("Unexpected metadata %+v", meta)
+		}
+	}
+	if err := writeDict(dir, ".dict", &dictMeta{ContentType: "text/css"}, []byte("body {")); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Neither a dictionary without metadata nor one that does not match its hash is loaded */
+	if err := ioutil.WriteFile(dir+"text__javascript.dict", []byte("function"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"text__css.v1.dict", []byte("body {"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"text__css.v1.dict.json", []byte(`{"content_type": "text/css", "version": "v1", "size": 6, "sha256": "00"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	*dictVersion = ""
+	dicts := loadDicts(dir, ".dict")
+	if len(dicts) != 2 || string(dicts["text/html"]) != "<html> v2" || string(dicts["text/css"]) != "body {" {
+		t.Errorf("newest: got %q", dicts)
+	}
+
+	*dictVersion = "v1"
+	dicts = loadDicts(dir, ".dict")
+	if len(dicts) != 1 || string(dicts["text/html"]) != "<html> v1" {
+		t.Errorf("v1: got %q", dicts)
+	}
+
+	if err := ioutil.WriteFile(dir+"text__html.v1.dict", []byte("<html> v3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readDict(dir + "text__html.v1.dict"); err == nil {
+		t.Errorf("Modified dictionary should not be valid")
+	}
+}
@@ -1,11 +1,8 @@
 package main
 
 import (
-	"archive/zip"
-	"bufio"
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
@@ -15,6 +12,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fedesog/webdriver"
 )
@@ -22,114 +21,93 @@ import (
 var desired, required webdriver.Capabilities
 var chromeDriver *webdriver.ChromeDriver
 
-func getHttp2UrlList(start, end int) map[string]bool {
-	http2List, err := http.Get(http2Url)
-	if err != nil {
-		log.Println(err)
-	}
-	defer http2List.Body.Close()
-
-	list := make(map[string]bool)
-
-	r := bufio.NewReader(http2List.Body)
-
-	for i := 0; i < end; i++ {
-		line, err := r.ReadString('\n')
-		if err != nil {
-			break
-		}
-		if i < start {
-			continue
-		}
-		list[strings.TrimSuffix(line, "\n")] = true
-	}
-	return list
+/* browserSession is what the chrome backend needs from a WebDriver session */
+type browserSession interface {
+	Url(url string) error
+	// hrefs of the links on the rendered page, in document order
+	Links() ([]string, error)
+	// Messages of the performance log, one JSON object each
+	PerformanceLog() ([]string, error)
+	Delete() error
 }
 
-type readerAt struct {
-	body []byte
+type browser interface {
+	NewSession() (browserSession, error)
 }
 
-func (r readerAt) ReadAt(p []byte, off int64) (n int, err error) {
-	n = copy(p, r.body[off:])
-
-	if n < len(p) {
-		err = fmt.Errorf("end of buffer")
-	}
-	return
+type chromeBrowser struct {
 }
 
-func getAlexaUrlList(start, end int) map[string]bool {
-	top, err := http.Get("http://s3.amazonaws.com/alexa-static/top-1m.csv.zip")
-	if err != nil {
-		log.Fatalln("Failed to download URL list", err)
-	}
-	defer top.Body.Close()
-
-	list := make(map[string]bool)
+type chromeSession struct {
+	*webdriver.Session
+}
 
-	body, err := ioutil.ReadAll(top.Body)
+func (b *chromeBrowser) NewSession() (browserSession, error) {
+	session, err := chromeDriver.NewSession(desired, required)
 	if err != nil {
-		log.Fatalln("Failed to download URL list", err)
+		return nil, err
 	}
+	// Don't wait more than that on any webpage
+	session.SetTimeouts("page load", 20*1000)
+	return &chromeSession{session}, nil
+}
 
-	zipped := readerAt{body}
-	r, err := zip.NewReader(zipped, int64(len(body)))
+func (s *chromeSession) Links() ([]string, error) {
+	elements, err := s.FindElements("partial link text", "")
 	if err != nil {
-		log.Fatalln("Failed to unzip URL list", err)
+		return nil, err
 	}
 
-	for _, f := range r.File {
-		rc, err := f.Open()
+	ret := make([]string, 0, len(elements))
+	for i, a := range elements {
+		if i > 50 {
+			break
+		}
+		// In this simplistic analysis we only chek hrefs
+		link, err := a.GetAttribute("href")
 		if err != nil {
-			log.Fatal(err)
+			continue
 		}
-		br := bufio.NewReader(rc)
-
-		for i := 0; i < end; i++ {
-			line, err := br.ReadString('\n')
-			if err != nil {
-				log.Println(err)
-				break
-			}
-
-			if i < start {
-				continue
-			}
+		ret = append(ret, link)
+	}
+	return ret, nil
+}
 
-			host := strings.Split(line, ",")[1]
-			list[strings.TrimSuffix(host, "\n")] = true
-		}
-		rc.Close()
+func (s *chromeSession) PerformanceLog() ([]string, error) {
+	logText, err := s.Log("performance")
+	if err != nil {
+		return nil, err
 	}
 
-	return list
+	ret := make([]string, len(logText))
+	for i, l := range logText {
+		ret[i] = l.Message
+	}
+	return ret, nil
 }
 
-func getLinks(session *webdriver.Session, site string) []string {
+func getLinks(session browserSession, site string) []string {
 	ret := make([]string, 0)
 
-	thisIps, err := net.LookupIP(strings.Split(strings.TrimPrefix(strings.TrimPrefix(site, "http://"), "https://"), "/")[0])
+	siteURL, err := url.Parse(site)
+	if err != nil {
+		log.Println(err)
+		return ret
+	}
+
+	thisIps, err := net.LookupIP(siteURL.Hostname())
 	if err != nil {
 		log.Println(err)
 		return ret
 	}
 	// Get all links on the rendered page
-	elements, err := session.FindElements("partial link text", "")
+	links, err := session.Links()
 	if err != nil {
 		log.Println(err)
 		return ret
 	}
 
-	for i, a := range elements {
-        if i > 50 {
-            break
-        }
-		// In this simplistic analysis we only chek hrefs
-		link, err := a.GetAttribute("href")
-		if err != nil {
-			continue
-		}
+	for _, link := range links {
 		// Parse the link
 		parsedLink, err := url.Parse(link)
 		if err != nil {
@@ -142,7 +120,7 @@ func getLinks(session *webdriver.Session, site string) []string {
 		// If host is not empty, check that it resolves to the same ip
 		if parsedLink.Host != "" {
 			matchIP := false
-			ips, err := net.LookupIP(parsedLink.Host)
+			ips, err := net.LookupIP(parsedLink.Hostname())
 			if err != nil {
 				continue
 			}
@@ -157,16 +135,13 @@ func getLinks(session *webdriver.Session, site string) []string {
 			}
 		}
 		// If the host is the same, do not allow index.html to be loaded twice
-		if parsedLink.Host == strings.TrimPrefix(strings.TrimPrefix(site, "https://"), "http://") || parsedLink.Host == "" {
+		if parsedLink.Host == siteURL.Host || parsedLink.Host == "" {
 			if parsedLink.Path == "" || parsedLink.Path == "/" || parsedLink.Path == "/index.html" {
 				continue
 			}
 		}
 
-		if parsedLink.Host == "" {
-			parsedLink.Host = site
-		}
-		ret = append(ret, parsedLink.String())
+		ret = append(ret, siteURL.ResolveReference(parsedLink).String())
 	}
 
 	dest := make([]string, len(ret))
@@ -177,16 +152,32 @@ func getLinks(session *webdriver.Session, site string) []string {
 	return dest
 }
 
-func downloadDataSet(address string, clicks int) {
-	log.Print(address)
+/* fetchedAsset is a response worth compressing, ready to be stored in the dataset */
+type fetchedAsset struct {
+	entry *manifestEntry
+	body  []byte
+}
+
+/* fetcher visits a site and returns its same-origin assets in load order, along with the final address */
+type fetcher interface {
+	fetch(address string, clicks int) (string, []*fetchedAsset, error)
+}
 
+/* chromeFetcher drives a real browser and takes the assets from its performance log */
+type chromeFetcher struct {
+	browser browser
+}
+
+func (f *chromeFetcher) fetch(address string, clicks int) (string, []*fetchedAsset, error) {
+	polite.wait(address)
 	resp, err := http.Head("http://" + address)
 	if err != nil {
 		log.Println(err)
+		polite.wait("www." + address)
 		resp, err = http.Head("http://www." + address)
 		if err != nil {
 			log.Println(err)
-			return
+			return "", nil, err
 		}
 	}
 	realAddress := resp.Request.URL.String()
@@ -195,20 +186,19 @@ func downloadDataSet(address string, clicks int) {
 
 	// We need the ips for later
 	// Start new session
-	session, err := chromeDriver.NewSession(desired, required)
+	session, err := f.browser.NewSession()
 	if err != nil {
 		log.Println(err)
-		return
+		return "", nil, err
 	}
 
 	defer session.Delete()
-	// Don't wait more than that on any webpage
-	err = session.SetTimeouts("page load", 20 * 1000)
 	// Try to navigate to the page
+	polite.wait(resp.Request.URL.Host)
 	err = session.Url(realAddress)
 	if err != nil {
 		log.Println("Navigation error:", err)
-	    return
+	    return "", nil, err
     }
 
 	links := getLinks(session, realAddress)
@@ -217,6 +207,9 @@ func downloadDataSet(address string, clicks int) {
 			break
 		}
 		log.Println("Click on: ", l)
+		if u, err := url.Parse(l); err == nil {
+			polite.wait(u.Host)
+		}
 		err = session.Url(l)
 		if err != nil {
 			log.Println("Navigation error:", err)
@@ -224,27 +217,20 @@ func downloadDataSet(address string, clicks int) {
 	}
 
 	// Performance log contains the network information
-	logText, err := session.Log("performance")
+	logText, err := session.PerformanceLog()
 	if err != nil {
 		log.Println("Error getting performance log:", err)
-		return
-	}
-
-	path := datapath + address
-	err = os.MkdirAll(path, 0777)
-	if err != nil {
-		log.Println("Could not create dir", err)
+		return "", nil, err
 	}
 
 	var IPaddr string
-	count := 0
-	manifest := ""
+	assets := make([]*fetchedAsset, 0)
 	client := &http.Client{}
 
 	for _, l := range logText {
 		var val map[string]interface{}
 
-		if err := json.Unmarshal([]byte(l.Message), &val); err != nil {
+		if err := json.Unmarshal([]byte(l), &val); err != nil {
 			log.Println(err)
 			continue
 		}
@@ -296,22 +282,11 @@ func downloadDataSet(address string, clicks int) {
 				continue
 			}
 
-			var contentType string
-			if contentType, ok = responseHeaders["content-type"].(string); !ok {
-				if contentType, ok = responseHeaders["Content-Type"].(string); !ok {
-					if contentType, ok = responseHeaders["Content-type"].(string); !ok {
-						continue
-					}
-				}
-			}
+			/* Without a content type, or with a generic one, the body decides */
+			contentType, _ := headerValue(responseHeaders, "content-type")
 			log.Println(thisUrl, contentType)
 
-			if _, ok := acceptedContent[strings.Split(contentType, ";")[0]]; !ok {
-				if !strings.HasPrefix(contentType, "image") &&
-					!strings.HasPrefix(contentType, "multipart") &&
-					!strings.HasSuffix(contentType, "woff") {
-					log.Println("Invalid Content Type for compression ", contentType)
-				}
+			if !mayAccept(contentType) {
 				continue
 			}
 			/* Download the asset for analysis */
@@ -330,6 +305,7 @@ func downloadDataSet(address string, clicks int) {
 				}
 			}
 
+			polite.wait(req.URL.Host)
 			res, err := client.Do(req)
 			if err != nil {
 				log.Println(err)
@@ -348,27 +324,115 @@ func downloadDataSet(address string, clicks int) {
 				continue
 			}
 
-			err = ioutil.WriteFile(path+"/"+strconv.Itoa(count), body, 0777)
-			if err != nil {
-				log.Println(err)
+			if !acceptAsset(contentType, body) {
 				continue
 			}
 
-			manifest += thisUrl + "{{{{" + contentType + "}}}}" + strconv.Itoa(len(body)) + "\n"
-			count++
+			entry := newManifestEntry(thisUrl, contentType, body)
+			entry.FinalURL = res.Request.URL.String()
+			entry.Status = res.StatusCode
+			entry.Headers = res.Header
+			/* The Go client transparently decodes gzip, so record what the browser received */
+			entry.ContentEncoding, _ = headerValue(responseHeaders, "content-encoding")
+			assets = append(assets, &fetchedAsset{entry: entry, body: body})
 		}
-		ioutil.WriteFile(path+"/manifest", []byte(manifest), 0777)
 	}
+
+	return realAddress, assets, nil
 }
 
-func download(webSites map[string]bool) {
+func downloadDataSet(f fetcher, address string, clicks int) error {
+	log.Print(address)
+
+	realAddress, assets, err := f.fetch(address, clicks)
+	if err != nil {
+		return err
+	}
+
+	/* Build the site in a hidden directory, it only becomes visible once the manifest is complete */
+	dir := siteDirName(address)
+	path := datapath + ".tmp-" + dir
+	os.RemoveAll(path)
+	err = os.MkdirAll(path, 0777)
+	if err != nil {
+		log.Println("Could not create dir", err)
+		return err
+	}
+
+	man := &manifest{Site: realAddress}
+	for i, a := range assets {
+		err = ioutil.WriteFile(path+"/"+strconv.Itoa(i), a.body, 0777)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		man.Assets = append(man.Assets, a.entry)
+	}
+
+	if err := writeManifest(path+"/manifest", man); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	os.RemoveAll(datapath + dir)
+	return os.Rename(path, datapath+dir)
+}
+
+/* Addresses from sitemaps include a path, which can't be used as is for a directory name */
+func siteDirName(address string) string {
+	return strings.Replace(address, "/", "__", -1)
+}
+
+func isAcceptedContent(contentType string) bool {
+	if _, ok := acceptedContent[strings.Split(contentType, ";")[0]]; !ok {
+		if !strings.HasPrefix(contentType, "image") &&
+			!strings.HasPrefix(contentType, "multipart") &&
+			!strings.HasSuffix(contentType, "woff") {
+			log.Println("Invalid Content Type for compression ", contentType)
+		}
+		return false
+	}
+	return true
+}
+
+/* Header names in the performance log keep whatever casing the server (or HTTP/2) used */
+func headerValue(headers map[string]interface{}, name string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			s, ok := v.(string)
+			return s, ok
+		}
+	}
+	return "", false
+}
+
+func download(webSites []string) {
+	var f fetcher
+
+	switch *backend {
+	case "http":
+		f = newHTTPFetcher()
+	case "chrome":
+		f = &chromeFetcher{browser: &chromeBrowser{}}
+	default:
+		log.Fatalln("Unknown download backend", *backend)
+	}
+
+	if _, ok := f.(*chromeFetcher); ok {
+		startChrome()
+		defer chromeDriver.Stop()
+	}
+
+	downloadSites(f, webSites)
+}
+
+func startChrome() {
 	chromeDriver = webdriver.NewChromeDriver(*chromedriverpath + "/chromedriver")
 	//chromeDriver.LogFile = "error.log"
 	err := chromeDriver.Start()
 	if err != nil {
 		log.Println(err)
 	}
-	defer chromeDriver.Stop()
 
 	logCapabilities := webdriver.Capabilities{
 		"browser":     "OFF",
@@ -394,8 +458,114 @@ func download(webSites map[string]bool) {
 	}
 
 	required = webdriver.Capabilities{}
+}
+
+func downloadSites(f fetcher, webSites []string) {
+	polite = newPoliteness(*hostDelay, *rateLimit)
+
+	journal := openJournal(journalPath(), *resume)
+	sites := journal.add(webSites)
+
+	n := *workers
+	if n < 1 {
+		n = 1
+	}
+
+	jobs := make(chan int)
+	results := make(chan downloadResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				var err error
+				for try := 0; try <= *retries; try++ {
+					time.Sleep(journal.backoff(sites[idx]))
+					journal.start(sites[idx])
+					err = downloadDataSet(f, sites[idx], *clicks)
+					journal.finish(sites[idx], err)
+					if err == nil {
+						break
+					}
+				}
+				results <- downloadResult{idx: idx, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for idx := range sites {
+			jobs <- idx
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	/* Report progress in site order, holding back results that finished early */
+	done := make(map[int]error)
+	next := 0
+	for res := range results {
+		done[res.idx] = res.err
+		for {
+			err, ok := done[next]
+			if !ok {
+				break
+			}
+			delete(done, next)
+			if err != nil {
+				log.Printf("[%d/%d] %s failed: %v", next+1, len(sites), sites[next], err)
+			} else {
+				log.Printf("[%d/%d] %s done", next+1, len(sites), sites[next])
+			}
+			next++
+		}
+	}
+}
+
+type downloadResult struct {
+	idx int
+	err error
+}
+
+/* politeness spaces out requests to the same host and caps the global request rate */
+type politeness struct {
+	sync.Mutex
+	delay   time.Duration
+	next    map[string]time.Time
+	limiter <-chan time.Time
+}
+
+var polite *politeness
 
-	for site := range webSites {
-		downloadDataSet(site, *clicks)
+func newPoliteness(delay time.Duration, rate float64) *politeness {
+	p := &politeness{delay: delay, next: make(map[string]time.Time)}
+	if rate > 0 {
+		p.limiter = time.Tick(time.Duration(float64(time.Second) / rate))
 	}
+	return p
+}
+
+func (p *politeness) wait(host string) {
+	if p == nil {
+		return
+	}
+
+	if p.limiter != nil {
+		<-p.limiter
+	}
+
+	p.Lock()
+	now := time.Now()
+	at, ok := p.next[host]
+	if !ok || at.Before(now) {
+		at = now
+	}
+	/* Reserve the slot before sleeping, so concurrent workers queue up behind each other */
+	p.next[host] = at.Add(p.delay)
+	p.Unlock()
+
+	time.Sleep(at.Sub(now))
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestChromeFetcher(t *testing.T) {
+	srv := fixtureServer()
+	defer srv.Close()
+	defer tempDataset(t)()
+
+	ip := "127.0.0.1"
+	b := &fakeBrowser{
+		links: []string{srv.URL + "/", srv.URL + "/about", "mailto:someone@example.com"},
+		log: []string{
+			perfResponse(srv.URL, ip, "GET", true, map[string]string{"content-type": "text/html; charset=utf-8"}),
+			`{"message":{"method":"Network.requestWillBeSent","params":{}}}`,
+			"not json",
+			perfResponse(srv.URL+"/style.css", ip, "GET", false, map[string]string{"Content-Type": "text/css"}),
+			perfResponse(srv.URL+"/app.js", ip, "GET", true, map[string]string{"CONTENT-TYPE": "application/javascript"}),
+			perfResponse(srv.URL+"/more.css", ip, "POST", true, map[string]string{"content-type": "text/css"}),
+			perfResponse(srv.URL+"/inline.css", ip, "POST", false, map[string]string{"content-type": "text/css"}),
+			perfResponse(srv.URL+"/font.woff", "10.0.0.1", "GET", true, map[string]string{"content-type": "application/font-woff"}),
+			perfResponse(srv.URL+"/logo.png", ip, "GET", true, map[string]string{"content-type": "image/png"}),
+			perfResponse(srv.URL+"/empty.js", ip, "GET", true, map[string]string{"content-type": "application/javascript"}),
+			perfResponse(srv.URL+"/about", ip, "GET", true, map[string]string{}),
+			perfResponse(srv.URL+"/about.js", ip, "GET", true, map[string]string{"Content-type": "text/javascript", "content-encoding": "gzip"}),
+		},
+	}
+
+	address := strings.TrimPrefix(srv.URL, "http://")
+	if err := downloadDataSet(&chromeFetcher{browser: b}, address, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.visited) != 2 || b.visited[0] != srv.URL || b.visited[1] != srv.URL+"/about" {
+		t.Errorf("Unexpected navigation %v", b.visited)
+	}
+
+	m, err := readManifest(datapath + address + "/manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version != manifestVersion || m.Site != srv.URL {
+		t.Errorf("Unexpected manifest header %d %s", m.Version, m.Site)
+	}
+
+	/* /about declares no type, its body says it is html */
+	expected := []string{"", "/style.css", "/app.js", "/about", "/about.js"}
+	if len(m.Assets) != len(expected) {
+		t.Fatalf("Expected %d assets, got %d", len(expected), len(m.Assets))
+	}
+
+	for i, e := range m.Assets {
+		page := fixturePages[expected[i]]
+		if e.URL != srv.URL+expected[i] {
+			t.Errorf("Asset %d: expected %s, got %s", i, srv.URL+expected[i], e.URL)
+			continue
+		}
+
+		content, err := ioutil.ReadFile(datapath + address + "/" + strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sum := sha256.Sum256(content)
+		if string(content) != page.body || e.Length != len(content) || e.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("Asset %d: content mismatch", i)
+		}
+		contentType := page.contentType
+		if expected[i] == "/about" {
+			contentType = ""
+			if e.Class != "html" {
+				t.Errorf("Asset %d: unexpected class %s", i, e.Class)
+			}
+		}
+		if e.Status != 200 || e.ContentType != contentType {
+			t.Errorf("Asset %d: unexpected status %d or type %s", i, e.Status, e.ContentType)
+		}
+	}
+
+	if m.Assets[4].ContentEncoding != "gzip" {
+		t.Errorf("Content encoding was not recorded")
+	}
+}
+
+func TestDownloadKeepsSiteHiddenOnFailure(t *testing.T) {
+	srv := fixtureServer()
+	defer srv.Close()
+	defer tempDataset(t)()
+
+	address := strings.TrimPrefix(srv.URL, "http://")
+	if err := downloadDataSet(&chromeFetcher{browser: &fakeBrowser{}}, address, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(siteDirs()) != 1 {
+		t.Fatalf("Expected one site")
+	}
+
+	if err := downloadDataSet(&chromeFetcher{browser: &fakeBrowser{}}, "does-not-exist.invalid", 0); err == nil {
+		t.Fatal("Expected error")
+	}
+	if len(siteDirs()) != 1 {
+		t.Errorf("Failed download should not be visible")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* slowFetcher serves made up sites, the first ones taking the longest so workers finish out of order */
+type slowFetcher struct {
+	sites int
+}
+
+func (f *slowFetcher) fetch(address string, clicks int) (string, []*fetchedAsset, error) {
+	n, _ := strconv.Atoi(address[len("site") : len(address)-len(".com")])
+	time.Sleep(time.Duration(f.sites-n) * 5 * time.Millisecond)
+
+	var assets []*fetchedAsset
+	for i := 0; i <= n%3; i++ {
+		body := []byte(fmt.Sprintf("<html>%s %d</html>", address, i))
+		assets = append(assets, &fetchedAsset{entry: newManifestEntry(fmt.Sprintf("http://%s/%d", address, i), "text/html", body), body: body})
+	}
+	return "http://" + address, assets, nil
+}
+
+/* datasetDigest is what downloadSites left on disk: the URLs and hashes of every site */
+func datasetDigest(t *testing.T) map[string][]string {
+	ret := make(map[string][]string)
+	for _, d := range siteDirs() {
+		m, err := readManifest(datapath + d.Name() + "/manifest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, e := range m.Assets {
+			body, err := ioutil.ReadFile(datapath + d.Name() + "/" + strconv.Itoa(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ret[d.Name()] = append(ret[d.Name()], e.URL, e.SHA256, string(body))
+		}
+	}
+	return ret
+}
+
+func TestDownloadSitesWorkers(t *testing.T) {
+	oldWorkers, oldDelay := *workers, *hostDelay
+	defer func() { *workers, *hostDelay = oldWorkers, oldDelay }()
+	*hostDelay = 0
+
+	var sites []string
+	for i := 0; i < 8; i++ {
+		sites = append(sites, fmt.Sprintf("site%d.com", i))
+	}
+
+	var digests []map[string][]string
+	for _, n := range []int{1, 4} {
+		cleanup := tempDataset(t)
+		*workers = n
+		downloadSites(&slowFetcher{sites: len(sites)}, sites)
+		digests = append(digests, datasetDigest(t))
+		cleanup()
+	}
+
+	if len(digests[0]) != len(sites) || !reflect.DeepEqual(digests[0], digests[1]) {
+		t.Errorf("-workers 1 and -workers 4 differ:\n%v\n%v", digests[0], digests[1])
+	}
+}
+
+func TestPoliteness(t *testing.T) {
+	delay := 40 * time.Millisecond
+	p := newPoliteness(delay, 0)
+
+	var lock sync.Mutex
+	var times []time.Time
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.wait("example.com")
+			lock.Lock()
+			times = append(times, time.Now())
+			lock.Unlock()
+		}()
+	}
+
+	/* Other hosts don't queue behind it */
+	p.wait("other.com")
+	if d := time.Since(start); d > delay/2 {
+		t.Errorf("Another host waited %v", d)
+	}
+	wg.Wait()
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < delay-5*time.Millisecond {
+			t.Errorf("Requests %d and %d to the same host %v apart, want %v", i-1, i, gap, delay)
+		}
+	}
+
+	/* The global rate applies to every host */
+	p = newPoliteness(0, 50)
+	start = time.Now()
+	for i := 0; i < 4; i++ {
+		p.wait(fmt.Sprintf("host%d.com", i))
+	}
+	if d := time.Since(start); d < 4*20*time.Millisecond-5*time.Millisecond {
+		t.Errorf("4 requests at 50 per second took %v", d)
+	}
+}
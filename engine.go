@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/* evalSite is a site of the dataset, its assets are loaded once and shared by all its cells */
+type evalSite struct {
+	name   string
+	fold   string
+	assets []*asset
+	cells  []*evalCell
+	/* Cells the workers have not finished yet */
+	pending sync.WaitGroup
+}
+
+/* evalCell is one (site, dictionary size, compressor, strategy, quality) combination of the grid */
+type evalCell struct {
+	site     *evalSite
+	size     int
+	c        compressor
+	strategy Strategy
+	quality  int
+
+	results  []*result
+	failures []string
+}
+
+/* engine evaluates the grid on a pool of workers, and hands the results to the sinks in a fixed order */
+type engine struct {
+	sinks    []resultSink
+	timing   bool
+	failures map[string]int
+
+	loadTimesLock sync.Mutex
+	loadTimes     map[string]time.Duration
+}
+
+func newEngine(sinks []resultSink, timing bool) *engine {
+	return &engine{sinks: sinks, timing: timing, failures: make(map[string]int), loadTimes: make(map[string]time.Duration)}
+}
+
+func loadSite(name, fold string) *evalSite {
+	man := parseManifest(datapath + name + "/manifest")
+
+	if len(man) <= *skip {
+		return nil
+	}
+
+	for _, m := range man {
+		content, err := ioutil.ReadFile(datapath + name + "/" + strconv.Itoa(m.idx))
+		if err != nil {
+			log.Print(err)
+		}
+		m.content = content
+		m.group = m.dictType()
+	}
+
+	site := &evalSite{name: name, fold: fold, assets: man}
+	for _, size := range grid.DictSizes {
+		for _, c := range grid.compressors {
+			for _, st := range grid.strategies {
+				for _, quality := range grid.qualities(c) {
+					site.cells = append(site.cells, &evalCell{site: site, size: size, c: c, strategy: st, quality: quality})
+				}
+			}
+		}
+	}
+
+	return site
+}
+
+/* Dictionaries repeat a lot between assets and strategies, time each one once per compressor and quality */
+func (e *engine) loadTime(c compressor, quality int, dict []byte, id string) time.Duration {
+	key := fmt.Sprintf("%s %d %s", c, quality, id)
+
+	e.loadTimesLock.Lock()
+	d, ok := e.loadTimes[key]
+	e.loadTimesLock.Unlock()
+	if ok {
+		return d
+	}
+
+	t := make(timings, *runs)
+	for i := range t {
+		t[i] = c.LoadDictTime(dict, quality)
+	}
+
+	e.loadTimesLock.Lock()
+	e.loadTimes[key] = t.median()
+	e.loadTimesLock.Unlock()
+	return t.median()
+}
+
+func (e *engine) evaluate(cell *evalCell) {
+	c, quality := cell.c, cell.quality
+	log.Println(cell.site.name, quality, c)
+
+	for _, r := range runStrategy(cell.strategy, cell.site.assets, c, quality, cell.size) {
+		res := newResult(cell.site.name, c, cell.strategy, quality, &r)
+		res.DictLimit = cell.size
+		res.Fold = cell.site.fold
+
+		/* CompressWithDict loads the dictionary every time, so its cost is taken out of the encode time and reported on its own */
+		encodeTime := r.encodeTime
+		if r.dict != nil {
+			load := e.loadTime(c, quality, r.dict, res.DictID)
+			res.DictLoadNs = int64(load)
+			encodeTime = encodeTime.minus(load)
+		}
+		res.setEncodeTime(encodeTime)
+
+		if e.timing || *doVerify {
+			var dec []byte
+			var err error
+			t := measure(*runs, func() {
+				dec, err = c.DecompressWithDict(r.compressed, r.dict)
+			})
+			res.setDecodeTime(t)
+
+			if *doVerify {
+				if err == nil && !bytes.Equal(dec, r.asset.content) {
+					err = fmt.Errorf("decompressed %d bytes, expected %d", len(dec), len(r.asset.content))
+				}
+				if err != nil {
+					log.Printf("Verification failed: %s, asset %d, %s, %s, quality %d, dictionary %s %d bytes: %v",
+						res.Site, res.Asset, res.Compressor, res.Strategy, quality, res.DictSource, res.DictSize, err)
+					cell.failures = append(cell.failures, fmt.Sprintf("%s, %s, quality %d", res.Compressor, res.Strategy, quality))
+				}
+			}
+		}
+
+		cell.results = append(cell.results, res)
+	}
+}
+
+/* run evaluates every site of a fold; at most -j-sites sites are held in memory at a time */
+func (e *engine) run(dirs []os.FileInfo, fold string) {
+	/* Timings taken while every core is busy measure contention rather than the codecs */
+	workers := *evalWorkers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+		if e.timing {
+			workers = 1
+		}
+	} else if e.timing && workers > 1 {
+		log.Printf("Timing with %d cells evaluated in parallel", workers)
+	}
+	window := *evalSites
+	if window < 1 {
+		window = 1
+	}
+
+	inflight := make(chan struct{}, window)
+	sites := make(chan *evalSite, window)
+	jobs := make(chan *evalCell)
+
+	/* Loader: reads the sites in order, and queues their cells for the workers */
+	go func() {
+		for _, d := range dirs {
+			inflight <- struct{}{}
+			site := loadSite(d.Name(), fold)
+			if site == nil {
+				<-inflight
+				continue
+			}
+
+			site.pending.Add(len(site.cells))
+			sites <- site
+			for _, cell := range site.cells {
+				jobs <- cell
+			}
+		}
+		close(sites)
+		close(jobs)
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for cell := range jobs {
+				e.evaluate(cell)
+				cell.site.pending.Done()
+			}
+		}()
+	}
+
+	/* Collector: hands the results to the sinks in site and cell order, whatever order the workers finish in */
+	for site := range sites {
+		site.pending.Wait()
+
+		for _, cell := range site.cells {
+			for _, res := range cell.results {
+				for _, sink := range e.sinks {
+					sink.add(res)
+				}
+			}
+			for _, f := range cell.failures {
+				e.failures[f]++
+			}
+		}
+
+		for _, sink := range e.sinks {
+			if err := sink.flush(); err != nil {
+				log.Println(err)
+			}
+		}
+
+		site.assets, site.cells = nil, nil
+		<-inflight
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/* recordSink keeps what the engine hands to the sinks, without the timings */
+type recordSink struct {
+	results []result
+}
+
+func (s *recordSink) add(r *result) error {
+	c := *r
+	c.EncodeNs, c.EncodeP90Ns, c.EncodeMBps = 0, 0, 0
+	c.DecodeNs, c.DecodeP90Ns, c.DecodeMBps = 0, 0, 0
+	c.DictLoadNs = 0
+	s.results = append(s.results, c)
+	return nil
+}
+
+func (s *recordSink) flush() error {
+	s.results = append(s.results, result{Site: "flush"})
+	return nil
+}
+
+func (s *recordSink) close() error {
+	return nil
+}
+
+/* engineDataset writes n sites of three small assets each */
+func engineDataset(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		dir := fmt.Sprintf("%ssite%d.com", datapath, i)
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		man := &manifest{Site: "http://site" + fmt.Sprint(i) + ".com/"}
+		bodies := []string{
+			strings.Repeat(fmt.Sprintf("<div class=\"item\">%d</div>", i), 20),
+			strings.Repeat(fmt.Sprintf("body { margin: %dpx }", i), 20),
+			strings.Repeat(fmt.Sprintf("var a = %d;", i), 20),
+		}
+		for j, ct := range []string{"text/html", "text/css", "text/javascript"} {
+			ioutil.WriteFile(fmt.Sprintf("%s/%d", dir, j), []byte(bodies[j]), 0644)
+			man.Assets = append(man.Assets, newManifestEntry(fmt.Sprintf("%s%d", man.Site, j), ct, []byte(bodies[j])))
+		}
+		if err := writeManifest(dir+"/manifest", man); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEngineDeterministic(t *testing.T) {
+	defer tempDataset(t)()
+	engineDataset(t, 4)
+
+	oldDicts, oldWorkers, oldGrid := dictpath, *evalWorkers, grid
+	defer func() { dictpath, *evalWorkers, grid = oldDicts, oldWorkers, oldGrid }()
+	dictpath = datapath + "no-dicts/"
+
+	*gridCompressors = "Deflate,Brotli"
+	*gridQualities = "Deflate=1,6;Brotli=4"
+	*gridStrategies = "none,previous,concat,same-type"
+	defer func() { *gridCompressors, *gridQualities, *gridStrategies = "", "", "" }()
+	var err error
+	if grid, err = newGrid("", map[string]bool{"compressors": true, "quality": true, "strategies": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var outputs [][]result
+	for _, j := range []int{1, 4} {
+		*evalWorkers = j
+		s := &recordSink{}
+		newEngine([]resultSink{s}, true).run(siteDirs(), "")
+		outputs = append(outputs, s.results)
+	}
+
+	if len(outputs[0]) != 4*(1+3*4*3) {
+		t.Errorf("Unexpected number of results %d", len(outputs[0]))
+	}
+	if !reflect.DeepEqual(outputs[0], outputs[1]) {
+		t.Errorf("-j 1 and -j 4 differ")
+	}
+}
+
+/* corrupter decompresses to something else whenever there is a dictionary */
+type corrupter struct {
+	gzipper
+}
+
+func (c *corrupter) String() string {
+	return "Corrupt"
+}
+
+func (c *corrupter) DecompressWithDict(in, dict []byte) ([]byte, error) {
+	out, err := c.gzipper.DecompressWithDict(in, dict)
+	if dict != nil && len(out) > 0 {
+		out[0]++
+	}
+	return out, err
+}
+
+func TestEngineVerify(t *testing.T) {
+	defer tempDataset(t)()
+	engineDataset(t, 2)
+
+	oldGrid := grid
+	defer func() { grid, *doVerify = oldGrid, false }()
+	*doVerify = true
+
+	grid = &testGrid{
+		Qualities:   map[string][]int{"Deflate": {1, 6}, "Corrupt": {1, 6}},
+		DictSizes:   []int{1024},
+		compressors: []compressor{&gzipper{}, &corrupter{}},
+		strategies:  []Strategy{strategyByName("none"), strategyByName("previous"), strategyByName("first")},
+	}
+
+	e := newEngine(nil, false)
+	e.run(siteDirs(), "")
+
+	/* Only the first asset of a site goes without a dictionary */
+	want := map[string]int{
+		"Corrupt, previous, quality 1": 4,
+		"Corrupt, previous, quality 6": 4,
+		"Corrupt, first, quality 1":    4,
+		"Corrupt, first, quality 6":    4,
+	}
+	if !reflect.DeepEqual(e.failures, want) {
+		t.Errorf("Unexpected failures %v", e.failures)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+/* A small website served by httptest, shared by the downloader tests */
+var fixturePages = map[string]struct {
+	contentType, body string
+}{
+	"": {"text/html; charset=utf-8", `<html><head>
+<link rel="stylesheet" href="/style.css">
+<script src="/app.js"></script>
+<script src="http://example.invalid/external.js"></script>
+<style>@import url("inline.css");</style>
+</head><body><a href="/about">About</a><a href="/">Home</a><img src="/logo.png"></body></html>`},
+	"/style.css":  {"text/css", `@import "more.css"; @font-face { src: url(/font.woff) } body { background: url('/logo.png') }`},
+	"/more.css":   {"text/css", `p { color: red }`},
+	"/inline.css": {"text/css", `h1 { color: blue }`},
+	"/app.js":     {"application/javascript", `console.log("app")`},
+	"/font.woff":  {"application/font-woff", "wOFF"},
+	"/logo.png":   {"image/png", "\x89PNG"},
+	"/about":      {"text/html", `<html><script src="/about.js"></script></html>`},
+	"/about.js":   {"text/javascript", `console.log("about")`},
+	"/empty.js":   {"application/javascript", ""},
+}
+
+func fixtureServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if path == "/" {
+			path = ""
+		}
+		p, ok := fixturePages[path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", p.contentType)
+		fmt.Fprint(w, p.body)
+	}))
+}
+
+/* tempDataset points datapath to a fresh directory, and returns a function that removes it */
+func tempDataset(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "dataset")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := datapath
+	datapath = dir + "/"
+	return func() {
+		datapath = old
+		os.RemoveAll(dir)
+	}
+}
+
+/* fakeBrowser replays a canned performance log instead of driving Chrome */
+type fakeBrowser struct {
+	links   []string
+	log     []string
+	visited []string
+}
+
+type fakeSession struct {
+	b *fakeBrowser
+}
+
+func (b *fakeBrowser) NewSession() (browserSession, error) {
+	return &fakeSession{b}, nil
+}
+
+func (s *fakeSession) Url(url string) error {
+	s.b.visited = append(s.b.visited, url)
+	return nil
+}
+
+func (s *fakeSession) Links() ([]string, error) {
+	return s.b.links, nil
+}
+
+func (s *fakeSession) PerformanceLog() ([]string, error) {
+	return s.b.log, nil
+}
+
+func (s *fakeSession) Delete() error {
+	return nil
+}
+
+/* perfResponse builds a Network.responseReceived message, HTTP/1 requests carry the raw request text instead of :method */
+func perfResponse(url, ip, method string, http2 bool, headers map[string]string) string {
+	request := map[string]interface{}{"User-Agent": "fake"}
+	response := map[string]interface{}{
+		"url":             url,
+		"remoteIPAddress": ip,
+		"headers":         headers,
+		"requestHeaders":  request,
+		"status":          200,
+	}
+	if http2 {
+		request[":method"] = method
+	} else {
+		response["requestHeadersText"] = method + " / HTTP/1.1\r\n"
+	}
+
+	msg, _ := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"method": "Network.responseReceived",
+			"params": map[string]interface{}{"response": response},
+		},
+	})
+	return string(msg)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+/* testGrid is the set of cells testStrategy evaluates for every site */
+type testGrid struct {
+	Compressors []string         `json:"compressors"`
+	Qualities   map[string][]int `json:"qualities"`
+	Strategies  []string         `json:"strategies"`
+	DictSizes   []int            `json:"dict_sizes"`
+
+	compressors []compressor
+	strategies  []Strategy
+}
+
+var grid *testGrid
+
+/* parseIntList parses lists like "1,4-8,11" */
+func parseIntList(s string) ([]int, error) {
+	ret := make([]int, 0)
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		if len(bounds) == 2 && bounds[0] != "" {
+			from, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, err
+			}
+			to, err := strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+			for i := from; i <= to; i++ {
+				ret = append(ret, i)
+			}
+			continue
+		}
+
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+
+	return ret, nil
+}
+
+/* uniqueInts drops repeated values, keeping the first of each */
+func uniqueInts(list []int) []int {
+	seen := make(map[int]bool)
+	ret := make([]int, 0, len(list))
+	for _, v := range list {
+		if !seen[v] {
+			seen[v] = true
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func compressorByName(name string) compressor {
+	for _, c := range compressors {
+		if strings.EqualFold(c.String(), name) {
+			return c
+		}
+	}
+	return nil
+}
+
+/* xlsxSheetNameLimit is the longest sheet name Excel accepts */
+const xlsxSheetNameLimit = 31
+
+/* newGrid builds the grid from the config file, if any, and the flags in set, which take precedence; repeated entries are dropped */
+func newGrid(config string, set map[string]bool) (*testGrid, error) {
+	/*
+	   Without either, the grid is the original one: every compressor and strategy, qualities 4 to 8.
+	   -bl, -dl and -zl, when given explicitly, select a single quality for Brotli, Deflate and Zstd, and for their DCB and DCZ transport forms.
+	*/
+	g := &testGrid{Qualities: make(map[string][]int)}
+
+	if config != "" {
+		raw, err := ioutil.ReadFile(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, g); err != nil {
+			return nil, fmt.Errorf("%s: %v", config, err)
+		}
+		/* Keys are compressor names in any case, as on the command line */
+		qualities := make(map[string][]int)
+		for name, levels := range g.Qualities {
+			c := compressorByName(strings.TrimSpace(name))
+			if c == nil {
+				return nil, fmt.Errorf("%s: unknown compressor %q in qualities", config, name)
+			}
+			qualities[c.String()] = levels
+		}
+		g.Qualities = qualities
+	}
+
+	if set["compressors"] {
+		g.Compressors = strings.Split(*gridCompressors, ",")
+	}
+	if len(g.Compressors) == 0 {
+		for _, c := range compressors {
+			g.Compressors = append(g.Compressors, c.String())
+		}
+	}
+
+	for _, name := range g.Compressors {
+		c := compressorByName(strings.TrimSpace(name))
+		if c == nil {
+			return nil, fmt.Errorf("unknown compressor %q", name)
+		}
+		if !g.hasCompressor(c) {
+			g.compressors = append(g.compressors, c)
+		}
+	}
+
+	if set["bl"] {
+		g.Qualities[(&brotler{}).String()] = []int{*bl}
+		g.Qualities[(&dcber{}).String()] = []int{*bl}
+	}
+	if set["dl"] {
+		g.Qualities[(&gzipper{}).String()] = []int{*dl}
+	}
+	if set["zl"] {
+		g.Qualities[(&zstder{}).String()] = []int{*zl}
+		g.Qualities[(&dczer{}).String()] = []int{*zl}
+	}
+	if set["quality"] {
+		/* Deflate=1-9;Brotli=4,11 */
+		for _, spec := range strings.Split(*gridQualities, ";") {
+			kv := strings.SplitN(spec, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid quality spec %q, expected compressor=levels", spec)
+			}
+			c := compressorByName(strings.TrimSpace(kv[0]))
+			if c == nil {
+				return nil, fmt.Errorf("unknown compressor %q", kv[0])
+			}
+			levels, err := parseIntList(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			g.Qualities[c.String()] = levels
+		}
+	}
+
+	for _, c := range g.compressors {
+		levels, ok := g.Qualities[c.String()]
+		if !ok || len(levels) == 0 {
+			levels = []int{4, 5, 6, 7, 8}
+		}
+		levels = uniqueInts(levels)
+		g.Qualities[c.String()] = levels
+		min, max := c.QualityRange()
+		for _, q := range levels {
+			if q < min || q > max {
+				return nil, fmt.Errorf("invalid %s quality %d: want value in range [%d, %d]", c, q, min, max)
+			}
+		}
+	}
+
+	if set["strategies"] {
+		g.Strategies = strings.Split(*gridStrategies, ",")
+	}
+	if len(g.Strategies) == 0 && len(sweepSizes) > 0 {
+		g.Strategies = sweepStrategies
+	}
+	if len(g.Strategies) == 0 {
+		for _, s := range strategies {
+			g.Strategies = append(g.Strategies, s.Name())
+		}
+	}
+	for _, name := range g.Strategies {
+		s := strategyByName(strings.TrimSpace(name))
+		if s == nil {
+			return nil, fmt.Errorf("unknown strategy %q, want one of %s", name, strategyNames())
+		}
+		if !g.hasStrategy(s) {
+			g.strategies = append(g.strategies, s)
+		}
+	}
+
+	if set["dict-sizes"] {
+		list, err := parseIntList(*gridDictSizes)
+		if err != nil {
+			return nil, err
+		}
+		g.DictSizes = list
+	}
+	if len(sweepSizes) > 0 {
+		/* The static dictionaries only exist at the sizes of the sweep */
+		if len(g.DictSizes) > 0 {
+			return nil, fmt.Errorf("-sweep sets the dictionary sizes, dict_sizes can not be given too")
+		}
+		g.DictSizes = sweepSizes
+	}
+	if len(g.DictSizes) == 0 {
+		g.DictSizes = []int{dictSize}
+	}
+	g.DictSizes = uniqueInts(g.DictSizes)
+
+	if *xlsxpath != "" {
+		for _, size := range g.DictSizes {
+			for _, c := range g.compressors {
+				for _, s := range g.strategies {
+					if name := g.sheetName(c.String(), s.Name(), size); len(name) > xlsxSheetNameLimit {
+						return nil, fmt.Errorf("xlsx sheet name %q is longer than %d characters, disable the xlsx report with -x \"\"", name, xlsxSheetNameLimit)
+					}
+				}
+			}
+		}
+	}
+
+	return g, nil
+}
+
+func (g *testGrid) hasCompressor(c compressor) bool {
+	for _, have := range g.compressors {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *testGrid) hasStrategy(s Strategy) bool {
+	for _, have := range g.strategies {
+		if have == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *testGrid) qualities(c compressor) []int {
+	return g.Qualities[c.String()]
+}
+
+/* sheetName names a compressor, strategy and dictionary size cell, the size only matters when there are several */
+func (g *testGrid) sheetName(compressor, strategy string, size int) string {
+	if len(g.DictSizes) > 1 {
+		return fmt.Sprintf("%s, %s, %s", compressor, strategy, sizeLabel(size))
+	}
+	return fmt.Sprintf("%s, %s", compressor, strategy)
+}
+
+/* sizeLabel writes sizes in whole K or M when it can, to keep sheet names short */
+func sizeLabel(size int) string {
+	switch {
+	case size > 0 && size%(1<<20) == 0:
+		return strconv.Itoa(size>>20) + "M"
+	case size > 0 && size%(1<<10) == 0:
+		return strconv.Itoa(size>>10) + "K"
+	}
+	return strconv.Itoa(size)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGridDuplicates(t *testing.T) {
+	*gridCompressors = "Brotli,brotli,Deflate"
+	*gridStrategies = "none,none,static-rolling"
+	*gridQualities = "Brotli=5,5,6"
+	*gridDictSizes = "16384,1048576,16384"
+	defer func() { *gridCompressors, *gridStrategies, *gridQualities, *gridDictSizes = "", "", "", "" }()
+	set := map[string]bool{"compressors": true, "strategies": true, "quality": true, "dict-sizes": true}
+
+	g, err := newGrid("", set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.compressors) != 2 || len(g.strategies) != 2 || len(g.qualities(&brotler{})) != 2 || len(g.DictSizes) != 2 {
+		t.Errorf("Unexpected grid %v %v %v %v", g.compressors, g.strategies, g.qualities(&brotler{}), g.DictSizes)
+	}
+
+	if name := g.sheetName("Deflate", "static-rolling", 1048576); name != "Deflate, static-rolling, 1M" {
+		t.Errorf("Unexpected sheet name %q", name)
+	}
+
+	*gridDictSizes = "16384,1048575"
+	if _, err := newGrid("", set); err == nil {
+		t.Errorf("Sheet names longer than %d characters should be rejected", xlsxSheetNameLimit)
+	}
+
+	old := *xlsxpath
+	*xlsxpath = ""
+	defer func() { *xlsxpath = old }()
+	if _, err := newGrid("", set); err != nil {
+		t.Errorf("Without the xlsx report any name is fine: %v", err)
+	}
+}
+
+func TestGridConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "grid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"compressors": ["brotli", "Deflate"], "qualities": {"brotli": [5, 6], "DEFLATE": [1]}, "strategies": ["none"]}`)
+	f.Close()
+
+	g, err := newGrid(f.Name(), map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g.qualities(&brotler{}), []int{5, 6}) || !reflect.DeepEqual(g.qualities(&gzipper{}), []int{1}) {
+		t.Errorf("Unexpected qualities %v", g.Qualities)
+	}
+
+	/* The flags still take precedence */
+	*gridQualities = "Deflate=9"
+	defer func() { *gridQualities = "" }()
+	if g, err = newGrid(f.Name(), map[string]bool{"quality": true}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g.qualities(&gzipper{}), []int{9}) {
+		t.Errorf("Unexpected Deflate qualities %v", g.qualities(&gzipper{}))
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"qualities": {"lzma": [1]}}`), 0644)
+	if _, err := newGrid(f.Name(), map[string]bool{}); err == nil {
+		t.Errorf("Unknown compressors in qualities should be rejected")
+	}
+}
+
+func TestGridLevelFlags(t *testing.T) {
+	oldBl, oldZl := *bl, *zl
+	defer func() { *bl, *zl = oldBl, oldZl }()
+	*bl, *zl = 9, 7
+
+	g, err := newGrid("", map[string]bool{"bl": true, "zl": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c, want := range map[compressor]int{&brotler{}: 9, &dcber{}: 9, &zstder{}: 7, &dczer{}: 7} {
+		if !reflect.DeepEqual(g.qualities(c), []int{want}) {
+			t.Errorf("%s qualities %v, want %d", c, g.qualities(c), want)
+		}
+	}
+}
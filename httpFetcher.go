@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const httpUserAgent = "Mozilla/5.0 (compatible; dict_crawler)"
+
+/* httpFetcher loads the landing page and the subresources its markup and stylesheets reference, without running JavaScript */
+type httpFetcher struct {
+	client *http.Client
+}
+
+func newHTTPFetcher() *httpFetcher {
+	return &httpFetcher{client: &http.Client{}}
+}
+
+var cssURLRE = regexp.MustCompile(`(?i)@import\s+(?:url\(\s*)?["']?([^"')\s;]+)|url\(\s*["']?([^"')]+?)["']?\s*\)`)
+
+/* cssLinks extracts @import and url() references from a stylesheet */
+func cssLinks(css []byte) []string {
+	ret := make([]string, 0)
+
+	for _, m := range cssURLRE.FindAllSubmatch(css, -1) {
+		link := string(m[1])
+		if link == "" {
+			link = string(m[2])
+		}
+		if link != "" && !strings.HasPrefix(link, "data:") {
+			ret = append(ret, link)
+		}
+	}
+
+	return ret
+}
+
+func attr(t html.Token, name string) string {
+	for _, a := range t.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+/* htmlLinks returns the subresources a browser would load for the page, and the anchors it could click */
+func htmlLinks(page []byte) (base string, resources, anchors []string) {
+	z := html.NewTokenizer(bytes.NewReader(page))
+	inStyle := false
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+
+		t := z.Token()
+		switch tt {
+		case html.TextToken:
+			if inStyle {
+				resources = append(resources, cssLinks([]byte(t.Data))...)
+			}
+			continue
+		case html.EndTagToken:
+			if t.Data == "style" {
+				inStyle = false
+			}
+			continue
+		case html.StartTagToken, html.SelfClosingTagToken:
+		default:
+			continue
+		}
+
+		switch t.Data {
+		case "base":
+			if base == "" {
+				base = attr(t, "href")
+			}
+		case "script":
+			if src := attr(t, "src"); src != "" {
+				resources = append(resources, src)
+			}
+		case "link":
+			for _, rel := range strings.Fields(strings.ToLower(attr(t, "rel"))) {
+				if rel == "stylesheet" || rel == "preload" || rel == "modulepreload" || rel == "icon" {
+					if href := attr(t, "href"); href != "" {
+						resources = append(resources, href)
+					}
+					break
+				}
+			}
+		case "style":
+			inStyle = tt == html.StartTagToken
+		case "a":
+			if href := attr(t, "href"); href != "" {
+				anchors = append(anchors, href)
+			}
+		}
+	}
+}
+
+/* statusError is a response that is not a 2xx, an error page that must not end up in the dataset */
+type statusError struct {
+	link   string
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: status %d", e.link, e.status)
+}
+
+/* visit fetches a single URL, and returns the asset if it is worth compressing */
+func (f *httpFetcher) visit(link string) (*fetchedAsset, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", httpUserAgent)
+
+	polite.wait(req.URL.Host)
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &statusError{link: link, status: res.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := res.Header.Get("Content-Type")
+	log.Println(link, contentType)
+
+	if len(body) == 0 || !acceptAsset(contentType, body) {
+		return nil, nil
+	}
+
+	entry := newManifestEntry(link, contentType, body)
+	entry.FinalURL = res.Request.URL.String()
+	entry.Status = res.StatusCode
+	entry.Headers = res.Header
+	return &fetchedAsset{entry: entry, body: body}, nil
+}
+
+/* visitResource is visit for subresources and clicks, which are just left out when missing */
+func (f *httpFetcher) visitResource(link string) (*fetchedAsset, error) {
+	asset, err := f.visit(link)
+	if e, ok := err.(*statusError); ok {
+		log.Println(e)
+		return nil, nil
+	}
+	return asset, err
+}
+
+func (f *httpFetcher) fetch(address string, clicks int) (string, []*fetchedAsset, error) {
+	landing := "http://" + address
+	page, err := f.visit(landing)
+	if err != nil {
+		log.Println(err)
+		landing = "http://www." + address
+		if page, err = f.visit(landing); err != nil {
+			log.Println(err)
+			return "", nil, err
+		}
+	}
+	if page == nil {
+		return landing, nil, nil
+	}
+
+	realAddress := page.entry.FinalURL
+	log.Println("Final address:", realAddress)
+
+	origin, err := url.Parse(realAddress)
+	if err != nil {
+		return "", nil, err
+	}
+
+	assets := []*fetchedAsset{page}
+	seen := map[string]bool{landing: true, realAddress: true, landing + "/": true}
+
+	/* Resolve a reference against the document it appears in, keeping only new same-origin http(s) URLs */
+	resolve := func(base *url.URL, ref string) (string, bool) {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host != origin.Host {
+			return "", false
+		}
+		u.Fragment = ""
+		if seen[u.String()] {
+			return "", false
+		}
+		seen[u.String()] = true
+		return u.String(), true
+	}
+
+	var anchors []string
+
+	/* Breadth first over the pages and the stylesheets they pull in, in document order */
+	for i := 0; i < len(assets); i++ {
+		a := assets[i]
+		base, err := url.Parse(a.entry.FinalURL)
+		if err != nil {
+			continue
+		}
+
+		var refs []string
+		switch strings.TrimSpace(strings.Split(a.entry.ContentType, ";")[0]) {
+		case "text/html", "application/xhtml+xml":
+			b, resources, links := htmlLinks(a.body)
+			if b != "" {
+				if u, err := base.Parse(b); err == nil {
+					base = u
+				}
+			}
+			refs = resources
+			if i == 0 {
+				for _, l := range links {
+					if u, err := base.Parse(l); err == nil && u.Host == origin.Host && u.Path != "" && u.Path != "/" && u.Path != "/index.html" {
+						anchors = append(anchors, u.String())
+					}
+				}
+			}
+		case "text/css":
+			refs = cssLinks(a.body)
+		}
+
+		for _, ref := range refs {
+			link, ok := resolve(base, ref)
+			if !ok {
+				continue
+			}
+			asset, err := f.visitResource(link)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if asset != nil {
+				assets = append(assets, asset)
+			}
+		}
+
+		/* Once the landing page is complete, "click" on random links like the browser backend does */
+		if i == 0 {
+			clicked := 0
+			for _, j := range rand.Perm(len(anchors)) {
+				if clicked == clicks {
+					break
+				}
+				link, ok := resolve(base, anchors[j])
+				if !ok {
+					continue
+				}
+				clicked++
+				log.Println("Click on: ", link)
+				asset, err := f.visitResource(link)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				if asset != nil {
+					assets = append(assets, asset)
+				}
+			}
+		}
+	}
+
+	return realAddress, assets, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHTTPFetcher(t *testing.T) {
+	srv := fixtureServer()
+	defer srv.Close()
+
+	defer tempDataset(t)()
+
+	address := strings.TrimPrefix(srv.URL, "http://")
+	if err := downloadDataSet(newHTTPFetcher(), address, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	man := parseManifest(datapath + address + "/manifest")
+
+	expected := []string{"", "/style.css", "/app.js", "/inline.css", "/about", "/more.css", "/font.woff", "/about.js"}
+	if len(man) != len(expected) {
+		t.Fatalf("Expected %d assets, got %d", len(expected), len(man))
+	}
+
+	for i, m := range man {
+		if m.path != srv.URL+expected[i] {
+			t.Errorf("Asset %d: expected %s, got %s", i, srv.URL+expected[i], m.path)
+			continue
+		}
+
+		content, err := ioutil.ReadFile(datapath + address + "/" + strconv.Itoa(m.idx))
+		if err != nil {
+			t.Error(err)
+		} else if string(content) != fixturePages[expected[i]].body {
+			t.Errorf("Asset %d: content mismatch", i)
+		}
+	}
+}
+
+func TestHTTPFetcherStatus(t *testing.T) {
+	srv := fixtureServer()
+	defer srv.Close()
+
+	f := newHTTPFetcher()
+	if asset, err := f.visitResource(srv.URL + "/missing.js"); asset != nil || err != nil {
+		t.Errorf("Missing subresource: got %v, %v", asset, err)
+	}
+	if _, err := f.visit(srv.URL + "/missing.js"); err == nil {
+		t.Errorf("Missing page should be an error")
+	}
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	defer tempDataset(t)()
+
+	address := strings.TrimPrefix(missing.URL, "http://")
+	if err := downloadDataSet(f, address, 1); err == nil {
+		t.Errorf("Site without a landing page should fail")
+	}
+	if len(siteDirs()) != 0 {
+		t.Errorf("Error page stored as a site")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Only the parts of HAR 1.2 we need */
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	ServerIPAddress string    `json:"serverIPAddress"`
+	Request         struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+	} `json:"request"`
+	Response struct {
+		Status  int         `json:"status"`
+		Headers []harHeader `json:"headers"`
+		Content struct {
+			Size     int    `json:"size"`
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+			Encoding string `json:"encoding"`
+		} `json:"content"`
+	} `json:"response"`
+}
+
+type harFile struct {
+	Log struct {
+		Version string     `json:"version"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+func (e *harEntry) header(name string) (string, bool) {
+	for _, h := range e.Response.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+func (e *harEntry) body() ([]byte, error) {
+	if e.Response.Content.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(e.Response.Content.Text)
+	}
+	return []byte(e.Response.Content.Text), nil
+}
+
+/* Site directories are named after the host of the first request, like the ones made by download */
+func harSiteName(entries []harEntry) string {
+	for _, e := range entries {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(u.Host); err == nil {
+			return host
+		}
+		return u.Host
+	}
+	return ""
+}
+
+func importHAR(harPath string) {
+	log.Print(harPath)
+
+	raw, err := ioutil.ReadFile(harPath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var har harFile
+	if err := json.Unmarshal(raw, &har); err != nil {
+		log.Println("Invalid HAR file", harPath, err)
+		return
+	}
+
+	address := harSiteName(har.Log.Entries)
+	if address == "" {
+		log.Println("No requests in", harPath)
+		return
+	}
+	if !validSiteName(address) {
+		log.Println("Invalid site name", address, "in", harPath)
+		return
+	}
+
+	path := datapath + address
+	err = os.MkdirAll(path, 0777)
+	if err != nil {
+		log.Println("Could not create dir", err)
+		return
+	}
+
+	var IPaddr, host string
+	count := 0
+	man := &manifest{Site: har.Log.Entries[0].Request.URL}
+
+	for i := range har.Log.Entries {
+		e := &har.Log.Entries[i]
+
+		/* Error pages and redirects are not what the site serves */
+		if e.Request.Method != "GET" || e.Response.Status < 200 || e.Response.Status > 299 {
+			continue
+		}
+
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			continue
+		}
+
+		/* Same rule as download: only keep what was served by the first server we saw.
+		   Captures without serverIPAddress fall back to the host name. */
+		if IPaddr == "" && host == "" {
+			IPaddr, host = e.ServerIPAddress, u.Host
+		} else if IPaddr != "" && strings.Trim(e.ServerIPAddress, "[]") != strings.Trim(IPaddr, "[]") {
+			continue
+		} else if IPaddr == "" && u.Host != host {
+			continue
+		}
+
+		contentType, ok := e.header("content-type")
+		if !ok {
+			contentType = e.Response.Content.MimeType
+		}
+		log.Println(e.Request.URL, contentType)
+
+		if !mayAccept(contentType) {
+			continue
+		}
+
+		body, err := e.body()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if len(body) == 0 || !acceptAsset(contentType, body) {
+			continue
+		}
+
+		err = ioutil.WriteFile(path+"/"+strconv.Itoa(count), body, 0777)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		entry := newManifestEntry(e.Request.URL, contentType, body)
+		entry.FinalURL = e.Request.URL
+		entry.Status = e.Response.Status
+		entry.ContentEncoding, _ = e.header("content-encoding")
+		entry.Headers = make(map[string][]string)
+		for _, h := range e.Response.Headers {
+			entry.Headers[h.Name] = append(entry.Headers[h.Name], h.Value)
+		}
+		if !e.StartedDateTime.IsZero() {
+			entry.FetchTime = e.StartedDateTime.UTC()
+		}
+		man.Assets = append(man.Assets, entry)
+		count++
+	}
+
+	if err := writeManifest(path+"/manifest", man); err != nil {
+		log.Println(err)
+	}
+}
+
+func importHARs(paths []string) {
+	for _, p := range paths {
+		if p != "" {
+			importHAR(p)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/* harEntryJSON builds a HAR entry, the body is base64 encoded when encoding is given */
+func harEntryJSON(method, url, ip, contentType, body, encoding string) string {
+	if encoding == "base64" {
+		body = base64.StdEncoding.EncodeToString([]byte(body))
+	}
+	headers := "[]"
+	if contentType != "" {
+		headers = fmt.Sprintf(`[{"name": "Content-Type", "value": %q}]`, contentType)
+	}
+	return fmt.Sprintf(`{"startedDateTime": "2020-01-02T03:04:05Z", "serverIPAddress": %q, "request": {"method": %q, "url": %q},
+		"response": {"status": 200, "headers": %s, "content": {"mimeType": "application/javascript", "text": %q, "encoding": %q}}}`,
+		ip, method, url, headers, body, encoding)
+}
+
+func harEntryStatus(status int, url string) string {
+	return strings.Replace(harEntryJSON("GET", url, "93.184.216.34", "text/html", "<html>error</html>", ""), `"status": 200`, fmt.Sprintf(`"status": %d`, status), 1)
+}
+
+func writeHAR(t *testing.T, entries ...string) string {
+	f, err := ioutil.TempFile("", "har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, `{"log": {"version": "1.2", "entries": [%s]}}`, strings.Join(entries, ","))
+	return f.Name()
+}
+
+func TestImportHAR(t *testing.T) {
+	defer tempDataset(t)()
+
+	ip := "93.184.216.34"
+	har := writeHAR(t,
+		harEntryJSON("GET", "https://example.com/", ip, "text/html", "<html></html>", ""),
+		harEntryJSON("GET", "https://example.com/a.css", ip, "text/css", "body { color: red }", "base64"),
+		harEntryJSON("GET", "https://cdn.example.com/b.js", "10.0.0.1", "text/javascript", "var b;", ""),
+		harEntryJSON("POST", "https://example.com/c.css", ip, "text/css", "p { color: blue }", ""),
+		harEntryJSON("GET", "https://example.com/logo.png", ip, "image/png", "\x89PNG\r\n\x1a\n", "base64"),
+		harEntryJSON("GET", "https://example.com/d.js", "["+ip+"]", "", "var d;", ""),
+		harEntryStatus(404, "https://example.com/missing.js"),
+		harEntryStatus(500, "https://example.com/error.css"),
+		harEntryStatus(301, "https://example.com/moved"),
+	)
+	/* Without server addresses, only the first host is kept */
+	byHost := writeHAR(t,
+		harEntryJSON("GET", "https://other.org:8443/", "", "text/html", "<html></html>", ""),
+		harEntryJSON("GET", "https://cdn.other.org/x.js", "", "text/javascript", "var x;", ""),
+		harEntryJSON("GET", "https://other.org:8443/y.css", "", "text/css", "a { color: red }", ""),
+	)
+	defer os.Remove(har)
+	defer os.Remove(byHost)
+	importHARs([]string{har, "", byHost})
+
+	tests := []struct {
+		site string
+		urls []string
+		body []string
+	}{
+		{"example.com", []string{"https://example.com/", "https://example.com/a.css", "https://example.com/d.js"}, []string{"<html></html>", "body { color: red }", "var d;"}},
+		{"other.org", []string{"https://other.org:8443/", "https://other.org:8443/y.css"}, []string{"<html></html>", "a { color: red }"}},
+	}
+
+	for _, test := range tests {
+		m, err := readManifest(datapath + test.site + "/manifest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Assets) != len(test.urls) {
+			t.Fatalf("%s: expected %d assets, got %d", test.site, len(test.urls), len(m.Assets))
+		}
+		for i, e := range m.Assets {
+			content, err := ioutil.ReadFile(datapath + test.site + "/" + strconv.Itoa(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.URL != test.urls[i] || string(content) != test.body[i] || e.Length != len(content) || e.Status != 200 {
+				t.Errorf("%s asset %d: got %s %q", test.site, i, e.URL, content)
+			}
+			if e.FetchTime.Year() != 2020 {
+				t.Errorf("%s asset %d: fetch time %v", test.site, i, e.FetchTime)
+			}
+		}
+	}
+
+	/* The content type falls back to the mime type of the body */
+	m, _ := readManifest(datapath + "example.com/manifest")
+	if m.Assets[2].ContentType != "application/javascript" {
+		t.Errorf("Unexpected content type %s", m.Assets[2].ContentType)
+	}
+}
+
+func TestImportHARSiteName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := datapath
+	datapath = dir + "/dataset/"
+	defer func() { datapath = old }()
+
+	har := writeHAR(t, harEntryJSON("GET", "http://../x.js", "", "text/javascript", "var x;", ""))
+	defer os.Remove(har)
+	importHAR(har)
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Site name escaped the dataset directory")
+	}
+}
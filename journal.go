@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	sitePending = "pending"
+	siteDone    = "done"
+	siteFailed  = "failed"
+)
+
+const maxBackoff = 5 * time.Minute
+
+type journalEntry struct {
+	State    string    `json:"state"`
+	Reason   string    `json:"reason,omitempty"`
+	Attempts int       `json:"attempts"`
+	Updated  time.Time `json:"updated"`
+}
+
+/* crawlJournal records the state of every site of a crawl, so an interrupted crawl can be resumed */
+type crawlJournal struct {
+	sync.Mutex
+	path  string
+	Sites map[string]*journalEntry `json:"sites"`
+}
+
+func journalPath() string {
+	return datapath + ".journal"
+}
+
+/* openJournal loads the journal from a previous crawl if resume is set, otherwise starts a new one */
+func openJournal(path string, resume bool) *crawlJournal {
+	j := &crawlJournal{path: path, Sites: make(map[string]*journalEntry)}
+
+	if !resume {
+		return j
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return j
+	}
+
+	if err := json.Unmarshal(raw, j); err != nil {
+		log.Println("Invalid crawl journal", err)
+	}
+	if j.Sites == nil {
+		j.Sites = make(map[string]*journalEntry)
+	}
+
+	return j
+}
+
+/* save must be called with the lock held */
+func (j *crawlJournal) save() {
+	out, err := json.MarshalIndent(j, "", "\t")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	os.MkdirAll(datapath, 0777)
+	if err := ioutil.WriteFile(j.path+".tmp", out, 0644); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := os.Rename(j.path+".tmp", j.path); err != nil {
+		log.Println(err)
+	}
+}
+
+func (j *crawlJournal) entry(site string) *journalEntry {
+	e, ok := j.Sites[site]
+	if !ok {
+		e = &journalEntry{State: sitePending}
+		j.Sites[site] = e
+	}
+	return e
+}
+
+/* add registers the sites of this crawl, and returns those that still need downloading */
+func (j *crawlJournal) add(sites []string) []string {
+	j.Lock()
+	defer j.Unlock()
+
+	ret := make([]string, 0, len(sites))
+	for _, site := range sites {
+		if e := j.entry(site); e.State != siteDone {
+			ret = append(ret, site)
+		}
+	}
+	j.save()
+
+	return ret
+}
+
+/* backoff returns how long to wait before the next attempt on a site that failed before */
+func (j *crawlJournal) backoff(site string) time.Duration {
+	j.Lock()
+	defer j.Unlock()
+
+	e := j.entry(site)
+	if e.State != siteFailed || e.Attempts == 0 {
+		return 0
+	}
+
+	delay := maxBackoff
+	if e.Attempts < 16 {
+		if d := time.Second << uint(e.Attempts-1); d < maxBackoff {
+			delay = d
+		}
+	}
+
+	return e.Updated.Add(delay).Sub(time.Now())
+}
+
+func (j *crawlJournal) start(site string) {
+	j.Lock()
+	defer j.Unlock()
+
+	e := j.entry(site)
+	e.State = sitePending
+	e.Attempts++
+	e.Updated = time.Now().UTC()
+	j.save()
+}
+
+func (j *crawlJournal) finish(site string, err error) {
+	j.Lock()
+	defer j.Unlock()
+
+	e := j.entry(site)
+	if err != nil {
+		e.State = siteFailed
+		e.Reason = err.Error()
+	} else {
+		e.State = siteDone
+		e.Reason = ""
+	}
+	e.Updated = time.Now().UTC()
+	j.save()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournalResume(t *testing.T) {
+	defer tempDataset(t)()
+
+	j := openJournal(journalPath(), false)
+	if sites := j.add([]string{"done.com", "failed.com", "pending.com"}); len(sites) != 3 {
+		t.Fatalf("New journal: got %v", sites)
+	}
+	j.start("done.com")
+	j.finish("done.com", nil)
+	j.start("failed.com")
+	j.finish("failed.com", errors.New("timeout"))
+	j.start("failed.com")
+	j.finish("failed.com", errors.New("refused"))
+	j.start("pending.com")
+
+	r := openJournal(journalPath(), true)
+	if e := r.Sites["failed.com"]; e.State != siteFailed || e.Attempts != 2 || e.Reason != "refused" {
+		t.Errorf("Unexpected failed entry %+v", e)
+	}
+	if e := r.Sites["pending.com"]; e.State != sitePending || e.Attempts != 1 {
+		t.Errorf("Unexpected pending entry %+v", e)
+	}
+	if e := r.Sites["done.com"]; e.State != siteDone || e.Attempts != 1 || e.Reason != "" {
+		t.Errorf("Unexpected done entry %+v", e)
+	}
+
+	want := []string{"failed.com", "pending.com", "new.com"}
+	if sites := r.add([]string{"done.com", "failed.com", "pending.com", "new.com"}); !reflect.DeepEqual(sites, want) {
+		t.Errorf("Resumed: got %v, want %v", sites, want)
+	}
+
+	/* Without -resume the previous crawl is ignored */
+	if sites := openJournal(journalPath(), false).add([]string{"done.com"}); len(sites) != 1 {
+		t.Errorf("Not resumed: got %v", sites)
+	}
+}
+
+func TestJournalBackoff(t *testing.T) {
+	defer tempDataset(t)()
+
+	j := openJournal(journalPath(), false)
+	if d := j.backoff("new.com"); d != 0 {
+		t.Errorf("New site: got %v", d)
+	}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{9, 256 * time.Second},
+		{10, maxBackoff},
+		{40, maxBackoff},
+	}
+	for _, test := range tests {
+		j.Sites["failed.com"] = &journalEntry{State: siteFailed, Attempts: test.attempts, Updated: time.Now()}
+		if d := j.backoff("failed.com"); d > test.want || d < test.want-time.Second {
+			t.Errorf("%d attempts: got %v, want %v", test.attempts, d, test.want)
+		}
+	}
+
+	j.Sites["failed.com"] = &journalEntry{State: siteFailed, Attempts: 3, Updated: time.Now().Add(-time.Hour)}
+	if d := j.backoff("failed.com"); d > 0 {
+		t.Errorf("Backoff should be over: got %v", d)
+	}
+}
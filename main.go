@@ -1,6 +1,11 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"log"
+	"strings"
+	"time"
+)
 
 var dictSize int = 32768
 
@@ -65,24 +70,84 @@ var acceptedContent = map[string]bool{
 }
 
 var doDownload = flag.Bool("d", false, "Download the dataset")
-var useAlexa = flag.Bool("a", true, "Use Alexa top for dataset (otherwise use isthewebhttp2yet dataset)")
+var useAlexa = flag.Bool("a", true, "Use Alexa top for dataset (otherwise use isthewebhttp2yet dataset), unless -list is given")
+var siteList = flag.String("list", "", "File, URL or - (stdin) with the list of sites to download, may be zipped or gzipped")
+var listFormat = flag.String("list-format", "auto", "Format of -list: auto, plain, csv (Alexa, Tranco, Umbrella), majestic or sitemap")
+var sample = flag.String("sample", "top", "How to pick -n sites from the list: top, random or stratified (by order of magnitude of rank)")
+var seed = flag.Int64("seed", 1, "Random seed for -sample")
+var listStart = flag.Int("start", 0, "Skip this many top sites of the list")
 var doGenDict = flag.Bool("dict", false, "Generate new shared dictionaries")
+var trainerName = flag.String("trainer", "dictator", "Dictionary trainer for -dict: dictator, cover or freq; use a different -dicts per trainer to compare them")
+var dictatorTable = flag.Int("dictator-table", 0, "dictator: size of the substring table (0 for half the dictionary size)")
+var dictatorWorkers = flag.Int("dictator-workers", 4, "dictator: how many files to process concurrently")
+var dictatorThreshold = flag.Float64("dictator-threshold", 1, "dictator: percentage of the files a string must appear in")
+var coverK = flag.Int("cover-k", 1024, "cover: segment size")
+var coverD = flag.Int("cover-d", 8, "cover: size of the substrings segments are scored by, 4 to 8")
+var freqLength = flag.Int("freq-length", 16, "freq: length of the substrings to count")
+var freqThreshold = flag.Float64("freq-threshold", 1, "freq: percentage of the files a substring must appear in")
+var trainMax = flag.Int("train-max", 32<<20, "cover, freq and -zdict: train on at most this many bytes of each content type, files picked evenly across the dataset (0 for all); memory use is tens of times this")
+var holdout = flag.Float64("holdout", 0, "Fraction of the sites to hold out of dictionary training, and evaluate on")
+var kFolds = flag.Int("folds", 1, "k-fold cross validation: train a set of dictionaries per fold, in <dicts>/fold-<n>/, on the other folds")
+var splitSeed = flag.Int64("split-seed", 1, "Seed of the assignment of sites to -holdout or -folds")
+var trainSites = flag.String("train-sites", "", "File with the sites to train dictionaries on, one per line (default all but -test-sites)")
+var testSites = flag.String("test-sites", "", "File with the sites to evaluate on, one per line (default all but -train-sites)")
+var dictEval = flag.String("dict-eval", "", "Report the gain, coverage, top substrings and dead regions of this dictionary on the dataset (the test sites of a -holdout or -test-sites split)")
+var evalType = flag.String("eval-type", "", "Content type of the assets for -dict-eval (default from the dictionary metadata)")
+var evalTop = flag.Int("eval-top", 20, "How many substrings -dict-eval lists")
+var evalDead = flag.Int("eval-dead", 64, "Shortest unreferenced region -dict-eval reports as dead")
+var typeGrouping = flag.String("type-groups", "", "How dictionaries group content types: canonical types by default, \"raw\" for the type strings as declared, or a JSON file mapping canonical types to group names")
+var typeSource = flag.String("type-source", "declared", "What dictionaries are keyed by: the declared content type, or the class detected from the body")
+var sniffTypes = flag.Bool("sniff", true, "Sniff the body of assets whose declared content type is missing, generic or wrong")
+var dictVersion = flag.String("dict-version", "", "Version of the dictionaries -dict saves (default the start of their SHA-256) and -c loads (default the newest of each type)")
+var useZDict = flag.Bool("zdict", false, "With -dict, also train ZDICT format dictionaries for zstd; with -c, zstd uses them instead of the .dict ones")
 var doCompressionTest = flag.Bool("c", false, "Perform compression test")
 var dataSetSize = flag.Int("n", 200, "How many websites to put into the dataset")
-var bl = flag.Int("bl", 4, "Brotli level")
+var bl = flag.Int("bl", 4, "Brotli level, also used by DCB")
 var dl = flag.Int("dl", 6, "Deflate level")
-var custom = flag.String("w", "", "download some other website instead")
+var zl = flag.Int("zl", 3, "Zstd level, also used by DCZ")
+var custom = flag.String("w", "", "download some other websites instead (comma separated)")
 var ds = flag.Int("ds", 32768, "size of the dictionary to use")
+var backend = flag.String("backend", "chrome", "Download backend: chrome (needs chromedriver) or http (plain HTTP client, no JavaScript)")
 var chromedriverpath = flag.String("cd", ".", "path to chromedriver")
 var dsp = flag.String("dataset", "./dataset/", "path to dataset")
 var dp = flag.String("dicts", "./dicts/", "path to dictionaries")
 var skip = flag.Int("skip", 0, "skip directories that have at most this many files")
 var clicks = flag.Int("clicks", 1, "How many \"clicks\" to simulate during download")
-var xlsxpath = flag.String("x", "./output.xlsx", "Where to save the xlsx file")
+var workers = flag.Int("workers", 1, "How many sites to download concurrently, each with its own browser session")
+var hostDelay = flag.Duration("host-delay", time.Second, "Minimum delay between requests to the same host during download")
+var rateLimit = flag.Float64("rate", 0, "Global limit of requests per second during download (0 for no limit)")
+var resume = flag.Bool("resume", false, "Resume an interrupted download, skipping sites the crawl journal marks as done")
+var retries = flag.Int("retries", 2, "How many times to retry a site that failed to download, with exponential backoff")
+var xlsxpath = flag.String("x", "./output.xlsx", "Where to save the xlsx file (empty to disable)")
+var csvpath = flag.String("csv", "", "Also save the results as CSV, one row per site, asset, compressor, strategy and quality")
+var jsonpath = flag.String("jsonl", "", "Also save the results as JSON Lines, one object per site, asset, compressor, strategy and quality")
+var harFiles = flag.String("har", "", "Build the dataset from these HAR files (comma separated) instead of downloading")
+var warcIn = flag.String("warc-in", "", "Build the dataset from these WARC files (comma separated)")
+var warcOut = flag.String("warc-out", "", "Export the dataset to this WARC file (gzipped if it ends with .gz)")
+var summarypath = flag.String("summary", "", "Save totals by content type, position in the page load and asset size as CSV")
+var assumeDictMatch = flag.String("dict-match", "", "For the transport strategy, treat responses without Use-As-Dictionary as if they had this match pattern, e.g. \"/*\"")
+var gridConfig = flag.String("config", "", "JSON file with the compression test grid: compressors, qualities, strategies and dict_sizes")
+var gridCompressors = flag.String("compressors", "", "Compressors to test, comma separated (default all)")
+var gridQualities = flag.String("quality", "", "Qualities per compressor, e.g. \"Deflate=1-9;Brotli=4,9-11\" (default 4-8)")
+var gridStrategies = flag.String("strategies", "", "Strategies to test by name, comma separated, e.g. \"none,static-rolling\" (default all, see -list-strategies)")
+var doListStrategies = flag.Bool("list-strategies", false, "List the available strategies and exit")
+var gridDictSizes = flag.String("dict-sizes", "", "Dictionary sizes for the dynamic strategies, comma separated (default -ds)")
+var sweep = flag.String("sweep", "", "Dictionary sizes to sweep, comma separated, e.g. \"16384,32768,65536,131072\": -dict trains a set per size in <dicts>/size-<n>/, -c evaluates the static strategies at every size")
+var sweepReport = flag.String("sweep-report", "./sweep.csv", "Where -sweep saves the bytes saved per dictionary type, strategy and size")
+var runs = flag.Int("runs", 1, "Repeat every compression and decompression this many times for timing, more than 1 implies -timing")
+var doTiming = flag.Bool("timing", false, "Measure decode times as well, with one compression test cell at a time unless -j says otherwise")
+var evalWorkers = flag.Int("j", 0, "How many compression test cells to evaluate in parallel (default the number of CPUs, or 1 with -timing)")
+var evalSites = flag.Int("j-sites", 2, "How many sites the compression test keeps loaded in memory at a time")
+var doVerify = flag.Bool("verify", false, "Verify that every compressed asset decompresses back with its dictionary")
 
 func main() {
 	flag.Parse()
 
+	if *doListStrategies {
+		listStrategies()
+		return
+	}
+
 	dictSize = *ds
 	datapath = *dsp
 	dictpath = *dp
@@ -96,27 +161,103 @@ func main() {
 	}
 
 	if *doDownload {
-		var webSites map[string]bool
+		var src urlSource
 		if *custom != "" {
-			webSites = map[string]bool{*custom: true}
+			src = staticSource(strings.Split(*custom, ","))
+		} else if *siteList != "" {
+			src = newURLSource(*siteList, *listFormat)
+		} else if *useAlexa {
+			src = newURLSource(alexaUrl, "csv")
 		} else {
-			if *useAlexa {
-				webSites = getAlexaUrlList(0, *dataSetSize)
-			} else {
-				webSites = getHttp2UrlList(0, *dataSetSize)
-			}
+			src = newURLSource(http2Url, "plain")
+		}
+
+		list, err := src.sites()
+		if err != nil {
+			log.Fatalln("Failed to get URL list", src, err)
+		}
+
+		webSites, err := sampleSites(list, *sample, *listStart, *dataSetSize, *seed)
+		if err != nil {
+			log.Fatalln(err)
 		}
 		download(webSites)
 	}
 
+	if *harFiles != "" {
+		importHARs(strings.Split(*harFiles, ","))
+	}
+
+	if *warcIn != "" {
+		importWARCs(strings.Split(*warcIn, ","))
+	}
+
+	if *warcOut != "" {
+		exportWARC(*warcOut)
+	}
+
+	if *runs < 1 {
+		*runs = 1
+	}
+
 	BrotliCompressionLevel = *bl
 	DeflateCompressionLevel = *dl
 
+	if *typeGrouping != "" && *typeGrouping != "raw" {
+		if err := loadTypeGroups(*typeGrouping); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if *sweep != "" {
+		var err error
+		if sweepSizes, err = parseIntList(*sweep); err != nil {
+			log.Fatalln(err)
+		}
+		sweepSizes = uniqueInts(sweepSizes)
+	}
+
+	var folds []*fold
+	if *doGenDict || *doCompressionTest || *dictEval != "" {
+		var err error
+		if folds, err = splitSites(siteDirs()); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	if *doGenDict {
-		genSharedDictionaries()
+		for _, f := range folds {
+			if f.label != "" {
+				log.Printf("Training on %d sites for %s", len(f.train), f.label)
+			}
+			if len(sweepSizes) == 0 {
+				genSharedDictionaries(f.train, f.dictDir, dictSize)
+			}
+			for _, size := range sweepSizes {
+				log.Printf("Training %d byte dictionaries", size)
+				genSharedDictionaries(f.train, staticDictDir(f.dictDir, size), size)
+			}
+		}
+	}
+
+	if *dictEval != "" {
+		dirs := siteDirs()
+		if len(folds) == 1 {
+			dirs = folds[0].test
+		}
+		evalDictionary(*dictEval, dirs)
 	}
 
 	if *doCompressionTest {
-		testStrategy()
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+
+		var err error
+		if grid, err = newGrid(*gridConfig, set); err != nil {
+			log.Fatalln(err)
+		}
+		testStrategy(folds)
 	}
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const manifestVersion = 1
+
+/* manifestEntry describes a single downloaded asset, stored as <idx> in the site directory */
+type manifestEntry struct {
+	URL             string              `json:"url"`
+	FinalURL        string              `json:"final_url,omitempty"`
+	Status          int                 `json:"status,omitempty"`
+	ContentType     string              `json:"content_type"`
+	ContentEncoding string              `json:"content_encoding,omitempty"`
+	Headers         map[string][]string `json:"headers,omitempty"`
+	FetchTime       time.Time           `json:"fetch_time"`
+	SHA256          string              `json:"sha256,omitempty"`
+	Length          int                 `json:"length"`
+	/* What the body looks like, whatever the content type says */
+	Class    string `json:"class,omitempty"`
+	Minified bool   `json:"minified,omitempty"`
+}
+
+type manifest struct {
+	Version int              `json:"version"`
+	Site    string           `json:"site"`
+	Assets  []*manifestEntry `json:"assets"`
+}
+
+type asset struct {
+	idx         int
+	path        string
+	contentType string
+	content     []byte
+	info        *manifestEntry
+	/* The dictionary type, once the content is loaded */
+	group string
+}
+
+var manifestRE *regexp.Regexp
+
+func init() {
+	manifestRE = regexp.MustCompile("(?i)" + "([^{]*[{]{0,3}){{{{([^}]*)}}}}([0-9]*)[\n]?")
+}
+
+func newManifestEntry(url, contentType string, body []byte) *manifestEntry {
+	sum := sha256.Sum256(body)
+	class, minified := classifyBody(body)
+	return &manifestEntry{
+		URL:         url,
+		ContentType: contentType,
+		FetchTime:   time.Now().UTC(),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Length:      len(body),
+		Class:       class,
+		Minified:    minified,
+	}
+}
+
+/* header returns the first value of a response header, whatever the case of its name in the manifest */
+func (e *manifestEntry) header(name string) string {
+	for k, v := range e.Headers {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+func writeManifest(path string, m *manifest) error {
+	m.Version = manifestVersion
+	out, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0777)
+}
+
+func readManifest(path string) (*manifest, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) == 0 || trimmed[0] != '{' {
+		return parseLegacyManifest(raw), nil
+	}
+
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+/* Legacy manifests are lines of url{{{{content-type}}}}size */
+func parseLegacyManifest(raw []byte) *manifest {
+	m := &manifest{}
+
+	sm := manifestRE.FindSubmatch(raw)
+	for len(sm) == 4 {
+		size, _ := strconv.Atoi(string(sm[3]))
+		m.Assets = append(m.Assets, &manifestEntry{URL: string(sm[1]), ContentType: string(sm[2]), Length: size})
+		raw = raw[len(sm[0]):]
+		sm = manifestRE.FindSubmatch(raw)
+	}
+
+	return m
+}
+
+/* siteDirs lists the site directories of the dataset, skipping the journal and sites still being downloaded */
+func siteDirs() []os.FileInfo {
+	ret := make([]os.FileInfo, 0)
+
+	dirs, _ := ioutil.ReadDir(datapath)
+	for _, d := range dirs {
+		if d.IsDir() && !strings.HasPrefix(d.Name(), ".") {
+			ret = append(ret, d)
+		}
+	}
+
+	return ret
+}
+
+func parseManifest(path string) []*asset {
+	ret := make([]*asset, 0)
+
+	m, err := readManifest(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return ret
+	}
+
+	for idx, e := range m.Assets {
+		ct := strings.Split(e.ContentType, ";")
+		ret = append(ret, &asset{idx: idx, path: e.URL, contentType: strings.TrimSpace(ct[0]), content: nil, info: e})
+	}
+
+	return ret
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	defer tempDataset(t)()
+
+	legacy := datapath + "legacy"
+	if err := ioutil.WriteFile(legacy, []byte("https://example.com/{{{{text/html; charset=utf-8}}}}1234\nhttps://example.com/a.css{{{{text/css}}}}56\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	current := datapath + "current"
+	body := []byte("body { color: red }")
+	want := &manifest{Site: "https://example.com/", Assets: []*manifestEntry{
+		newManifestEntry("https://example.com/", "text/html; charset=utf-8", []byte("<html></html>")),
+		newManifestEntry("https://example.com/a.css", "text/css", body),
+	}}
+	want.Assets[1].Status = 200
+	want.Assets[1].Headers = map[string][]string{"Content-Encoding": {"br"}}
+	if err := writeManifest(current, want); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{legacy, current} {
+		m, err := readManifest(path)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		if len(m.Assets) != 2 || m.Assets[0].URL != "https://example.com/" || m.Assets[1].URL != "https://example.com/a.css" ||
+			m.Assets[0].ContentType != "text/html; charset=utf-8" || m.Assets[1].ContentType != "text/css" {
+			t.Errorf("%s: unexpected assets %+v", path, m.Assets)
+		}
+
+		/* parseManifest strips the parameters of the content type */
+		assets := parseManifest(path)
+		if len(assets) != 2 || assets[0].contentType != "text/html" || assets[1].idx != 1 {
+			t.Errorf("%s: unexpected parsed assets", path)
+		}
+	}
+
+	m, _ := readManifest(legacy)
+	if m.Version != 0 || m.Assets[0].Length != 1234 || m.Assets[1].Length != 56 {
+		t.Errorf("Unexpected legacy manifest %d %d %d", m.Version, m.Assets[0].Length, m.Assets[1].Length)
+	}
+
+	m, _ = readManifest(current)
+	e := m.Assets[1]
+	if m.Version != manifestVersion || m.Site != want.Site || e.SHA256 != want.Assets[1].SHA256 || e.Length != len(body) ||
+		e.Status != 200 || e.header("content-encoding") != "br" || !e.FetchTime.Equal(want.Assets[1].FetchTime) {
+		t.Errorf("Unexpected manifest %+v", e)
+	}
+
+	if assets := parseManifest(datapath + "missing"); len(assets) != 0 {
+		t.Errorf("Missing manifest should have no assets")
+	}
+	if _, err := readManifest(datapath + "missing"); !os.IsNotExist(err) {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
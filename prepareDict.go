@@ -1,87 +1,92 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
 	"log"
-	"math"
 	"os"
-	"regexp"
 	"strconv"
-	"strings"
-
-	"github.com/vkrasnov/dictator"
 )
 
-type asset struct {
-	idx         int
-	path        string
-	contentType string
-	content     []byte
-}
-
-var manifestRE *regexp.Regexp
-
-func init() {
-	manifestRE = regexp.MustCompile("(?i)" + "([^{]*[{]{0,3}){{{{([^}]*)}}}}([0-9]*)[\n]?")
-}
-
-func parseManifest(path string) []*asset {
-	ret := make([]*asset, 0)
-
-	manifest, _ := ioutil.ReadFile(path)
-	sm := manifestRE.FindSubmatch(manifest)
-	idx := 0
-	for len(sm) == 4 {
-		ct := strings.Split(string(sm[2]), ";")
-		ret = append(ret, &asset{idx: idx, path: string(sm[1]), contentType: ct[0], content: nil})
-		manifest = manifest[len(sm[0]):]
-		sm = manifestRE.FindSubmatch(manifest)
-		idx++
-	}
-
-	return ret
-}
-
-func genSharedDictionaries() {
-	dirs, _ := ioutil.ReadDir(datapath)
-
+/* genSharedDictionaries trains a dictionary of the given size per content type on the given sites, saved in dir */
+func genSharedDictionaries(dirs []os.FileInfo, dir string, size int) {
 	fileByType := make(map[string]*[]string)
+	sitesByType := make(map[string]map[string]bool)
 
 	for _, d := range dirs {
 		man := parseManifest(datapath + d.Name() + "/manifest")
 		for _, m := range man {
-			files := fileByType[m.contentType]
+			path := datapath + d.Name() + "/" + strconv.Itoa(m.idx)
+			ct := assetDictType(m.contentType, m.info, readHead(path, classifyLen))
+
+			files := fileByType[ct]
 			if files == nil {
 				f := make([]string, 0)
 				files = &f
-				fileByType[m.contentType] = files
+				fileByType[ct] = files
+			}
+			*files = append(*files, path)
+
+			if sitesByType[ct] == nil {
+				sitesByType[ct] = make(map[string]bool)
 			}
-			*files = append(*files, datapath+d.Name()+"/"+strconv.Itoa(m.idx))
+			sitesByType[ct][d.Name()] = true
 		}
 	}
 
 	if len(fileByType) > 0 {
-		os.MkdirAll(dictpath, 0777)
+		os.MkdirAll(dir, 0777)
+	}
+
+	t, err := newTrainer(*trainerName)
+	if err != nil {
+		log.Println(err)
+		return
 	}
 
 	for name, paths := range fileByType {
-		log.Println(name)
-		progress := make(chan float64, len(*paths))
-		go func() {
-			for percent := range progress {
-				fmt.Printf("\r%.2f%% ", percent)
-			}
-		}()
-		table := dictator.GenerateTable(dictSize/2, *paths, DeflateCompressionLevel, progress, 4)
-		fmt.Println("\r100%  ")
-		fmt.Println("Total incompressible strings found: ", len(table))
+		log.Println(name, t)
+		hash, err := trainingHash(*paths)
+		if err != nil {
+			log.Println(name, err)
+			continue
+		}
+		meta := dictMeta{
+			ContentType:    name,
+			TargetSize:     size,
+			Trainer:        t.String(),
+			Params:         t.params(),
+			TrainingSHA256: hash,
+			Sites:          len(sitesByType[name]),
+			Samples:        len(*paths),
+		}
 
-		dictionary := dictator.GenerateDictionary(table, dictSize, int(math.Ceil(float64(len(*paths))*0.01)))
-		name = strings.Replace(name, "/", "__", -1)
-		err := ioutil.WriteFile(dictpath+name+".dict", []byte(dictionary), 0644)
+		dictionary, err := t.train(*paths, size)
 		if err != nil {
+			log.Println(name, err)
+		} else if err := writeDict(dir, ".dict", &meta, dictionary); err != nil {
 			log.Println(err)
 		}
+
+		if *useZDict {
+			if err := genZstdDictionary(meta, *paths, dir); err != nil {
+				log.Println(name, err)
+			}
+		}
+	}
+}
+
+/* genZstdDictionary trains a ZDICT format dictionary on the same files, saved next to the .dict one */
+func genZstdDictionary(meta dictMeta, paths []string, dir string) error {
+	samples, err := readSamples(paths, *trainMax)
+	if err != nil {
+		return err
 	}
+
+	dictionary, err := trainZstdDict(meta.ContentType, samples, meta.TargetSize)
+	if err != nil {
+		return err
+	}
+
+	meta.Trainer = "zstd"
+	meta.Params = map[string]string{"hash_bytes": "6", "max_bytes": strconv.Itoa(*trainMax)}
+	return writeDict(dir, ".zdict", &meta, dictionary)
 }
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+/* result is a single compressed asset, the unit every output sink works with */
+type result struct {
+	Site           string `json:"site"`
+	Fold           string `json:"fold,omitempty"`
+	Asset          int    `json:"asset"`
+	URL            string `json:"url"`
+	ContentType    string `json:"content_type"`
+	DictType       string `json:"dict_type"`
+	Compressor     string `json:"compressor"`
+	Strategy       string `json:"strategy"`
+	Quality        int    `json:"quality"`
+	OriginalSize   int    `json:"original_size"`
+	CompressedSize int    `json:"compressed_size"`
+	DictLimit      int    `json:"dict_limit"`
+	DictSource     string `json:"dict_source"`
+	DictID         string `json:"dict_id,omitempty"`
+	DictSize       int    `json:"dict_size"`
+	/* Times are the median and 90th percentile over -runs repetitions; encode times leave out the dictionary load, which is dict_load_ns */
+	EncodeNs    int64   `json:"encode_ns"`
+	EncodeP90Ns int64   `json:"encode_p90_ns"`
+	EncodeMBps  float64 `json:"encode_mbps"`
+	DecodeNs    int64   `json:"decode_ns"`
+	DecodeP90Ns int64   `json:"decode_p90_ns"`
+	DecodeMBps  float64 `json:"decode_mbps"`
+	DictLoadNs  int64   `json:"dict_load_ns"`
+}
+
+/* resultSink receives the results of testStrategy, flush is called after every site */
+type resultSink interface {
+	add(r *result) error
+	flush() error
+	close() error
+}
+
+/* dictID identifies a dictionary by its content, so results from different strategies can be compared */
+func dictID(dict []byte) string {
+	if dict == nil {
+		return ""
+	}
+	sum := sha256.Sum256(dict)
+	return hex.EncodeToString(sum[:8])
+}
+
+func newResult(site string, c compressor, s Strategy, quality int, r *assetResult) *result {
+	return &result{
+		Site:           site,
+		Asset:          r.asset.idx,
+		URL:            r.asset.path,
+		ContentType:    r.asset.contentType,
+		DictType:       r.asset.dictType(),
+		Compressor:     c.String(),
+		Strategy:       s.Name(),
+		Quality:        quality,
+		OriginalSize:   len(r.asset.content),
+		CompressedSize: len(r.compressed),
+		DictSource:     r.dictSource,
+		DictID:         dictID(r.dict),
+		DictSize:       len(r.dict),
+	}
+}
+
+func (r *result) setEncodeTime(t timings) {
+	r.EncodeNs = int64(t.median())
+	r.EncodeP90Ns = int64(t.p90())
+	r.EncodeMBps = throughput(r.OriginalSize, t.median())
+}
+
+func (r *result) setDecodeTime(t timings) {
+	r.DecodeNs = int64(t.median())
+	r.DecodeP90Ns = int64(t.p90())
+	r.DecodeMBps = throughput(r.OriginalSize, t.median())
+}
+
+/* xlsxSink keeps the original report: a sheet per grid cell, a row per site with the total per quality */
+type xlsxSink struct {
+	path      string
+	file      *xlsx.File
+	sheets    map[string]*xlsx.Sheet
+	qualities map[string][]int
+	order     []string
+	totals    map[string]map[int]int
+	site      string
+	fold      string
+	/* With a split dataset every row says which fold its site was in */
+	folded bool
+}
+
+func newXLSXSink(path string) (*xlsxSink, error) {
+	s := &xlsxSink{path: path, file: xlsx.NewFile(), sheets: make(map[string]*xlsx.Sheet),
+		qualities: make(map[string][]int), totals: make(map[string]map[int]int), folded: datasetSplit()}
+
+	/* For each compression algorithm and each stratgy we will have own sheet */
+	for _, size := range grid.DictSizes {
+		for _, c := range grid.compressors {
+			for _, st := range grid.strategies {
+				name := grid.sheetName(c.String(), st.Name(), size)
+				sheet, err := s.file.AddSheet(name)
+				if err != nil {
+					return nil, err
+				}
+				row := sheet.AddRow()
+				row.AddCell().Value = "Website"
+				if s.folded {
+					row.AddCell().Value = "Fold"
+				}
+				for _, q := range grid.qualities(c) {
+					row.AddCell().Value = "Quality" + strconv.Itoa(q)
+				}
+				s.sheets[name] = sheet
+				s.qualities[name] = grid.qualities(c)
+				s.order = append(s.order, name)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (s *xlsxSink) add(r *result) error {
+	name := grid.sheetName(r.Compressor, r.Strategy, r.DictLimit)
+	if s.totals[name] == nil {
+		s.totals[name] = make(map[int]int)
+	}
+	s.totals[name][r.Quality] += r.CompressedSize
+	s.site = r.Site
+	s.fold = r.Fold
+	return nil
+}
+
+func (s *xlsxSink) flush() error {
+	if s.site == "" {
+		return nil
+	}
+
+	for _, name := range s.order {
+		row := s.sheets[name].AddRow()
+		row.AddCell().Value = s.site
+		if s.folded {
+			row.AddCell().Value = s.fold
+		}
+		for _, q := range s.qualities[name] {
+			row.AddCell().SetInt(s.totals[name][q])
+		}
+	}
+
+	s.totals = make(map[string]map[int]int)
+	s.site = ""
+	return s.file.Save(s.path)
+}
+
+func (s *xlsxSink) close() error {
+	return s.flush()
+}
+
+/* csvSink writes one row per result, in long format */
+type csvSink struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func newCSVSink(path string) (*csvSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &csvSink{f: f, w: csv.NewWriter(f)}
+	s.w.Write([]string{"site", "fold", "asset", "url", "content_type", "dict_type", "compressor", "strategy", "quality",
+		"original_size", "compressed_size", "dict_limit", "dict_source", "dict_id", "dict_size",
+		"encode_ns", "encode_p90_ns", "encode_mbps", "decode_ns", "decode_p90_ns", "decode_mbps", "dict_load_ns"})
+	return s, nil
+}
+
+func (s *csvSink) add(r *result) error {
+	return s.w.Write([]string{r.Site, r.Fold, strconv.Itoa(r.Asset), r.URL, r.ContentType, r.DictType, r.Compressor, r.Strategy, strconv.Itoa(r.Quality),
+		strconv.Itoa(r.OriginalSize), strconv.Itoa(r.CompressedSize), strconv.Itoa(r.DictLimit), r.DictSource, r.DictID, strconv.Itoa(r.DictSize),
+		strconv.FormatInt(r.EncodeNs, 10), strconv.FormatInt(r.EncodeP90Ns, 10), strconv.FormatFloat(r.EncodeMBps, 'f', 2, 64),
+		strconv.FormatInt(r.DecodeNs, 10), strconv.FormatInt(r.DecodeP90Ns, 10), strconv.FormatFloat(r.DecodeMBps, 'f', 2, 64),
+		strconv.FormatInt(r.DictLoadNs, 10)})
+}
+
+func (s *csvSink) flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) close() error {
+	s.flush()
+	return s.f.Close()
+}
+
+/* jsonSink writes one JSON object per line */
+type jsonSink struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newJSONSink(path string) (*jsonSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *jsonSink) add(r *result) error {
+	return s.enc.Encode(r)
+}
+
+func (s *jsonSink) flush() error {
+	return s.f.Sync()
+}
+
+func (s *jsonSink) close() error {
+	return s.f.Close()
+}
+
+/* summarySink aggregates results by content type, position in the page load and size */
+type summarySink struct {
+	path   string
+	totals map[summaryKey]*summaryTotal
+}
+
+type summaryKey struct {
+	dimension, bucket, compressor, strategy string
+	quality, dictLimit                      int
+}
+
+type summaryTotal struct {
+	assets, original, compressed int
+	encodeNs, decodeNs           int64
+}
+
+func newSummarySink(path string) *summarySink {
+	return &summarySink{path: path, totals: make(map[summaryKey]*summaryTotal)}
+}
+
+func positionBucket(idx int) string {
+	switch {
+	case idx < 5:
+		return strconv.Itoa(idx)
+	case idx < 10:
+		return "5-9"
+	case idx < 20:
+		return "10-19"
+	default:
+		return "20+"
+	}
+}
+
+var sizeBuckets = []struct {
+	limit int
+	name  string
+}{
+	{1 << 10, "<1K"},
+	{4 << 10, "1K-4K"},
+	{16 << 10, "4K-16K"},
+	{64 << 10, "16K-64K"},
+	{256 << 10, "64K-256K"},
+}
+
+func sizeBucket(size int) string {
+	for _, b := range sizeBuckets {
+		if size < b.limit {
+			return b.name
+		}
+	}
+	return "256K+"
+}
+
+func (s *summarySink) add(r *result) error {
+	buckets := [][2]string{
+		{"content_type", r.ContentType},
+		{"position", positionBucket(r.Asset)},
+		{"size", sizeBucket(r.OriginalSize)},
+	}
+	if r.Fold != "" {
+		buckets = append(buckets, [2]string{"fold", r.Fold})
+	}
+
+	for _, b := range buckets {
+		key := summaryKey{dimension: b[0], bucket: b[1], compressor: r.Compressor, strategy: r.Strategy, quality: r.Quality, dictLimit: r.DictLimit}
+		t, ok := s.totals[key]
+		if !ok {
+			t = &summaryTotal{}
+			s.totals[key] = t
+		}
+		t.assets++
+		t.original += r.OriginalSize
+		t.compressed += r.CompressedSize
+		t.encodeNs += r.EncodeNs
+		t.decodeNs += r.DecodeNs
+	}
+	return nil
+}
+
+func (s *summarySink) flush() error {
+	return nil
+}
+
+/* The summary is written at the end, with the saving of every strategy over the reference strategy */
+func (s *summarySink) close() error {
+	keys := make([]summaryKey, 0, len(s.totals))
+	for k := range s.totals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.dimension != b.dimension {
+			return a.dimension < b.dimension
+		}
+		if a.bucket != b.bucket {
+			return a.bucket < b.bucket
+		}
+		if a.compressor != b.compressor {
+			return a.compressor < b.compressor
+		}
+		if a.strategy != b.strategy {
+			return strategyIndex(a.strategy) < strategyIndex(b.strategy)
+		}
+		if a.dictLimit != b.dictLimit {
+			return a.dictLimit < b.dictLimit
+		}
+		return a.quality < b.quality
+	})
+
+	f, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"dimension", "bucket", "compressor", "strategy", "dict_limit", "quality", "assets", "original_size", "compressed_size", "ratio", "saved_vs_" + referenceStrategy, "encode_mbps", "decode_mbps"})
+
+	for _, k := range keys {
+		t := s.totals[k]
+		saved := ""
+		ref := k
+		ref.strategy = referenceStrategy
+		if r, ok := s.totals[ref]; ok {
+			saved = strconv.Itoa(r.compressed - t.compressed)
+		}
+		ratio := 0.0
+		if t.compressed != 0 {
+			ratio = float64(t.original) / float64(t.compressed)
+		}
+		w.Write([]string{k.dimension, k.bucket, k.compressor, k.strategy, strconv.Itoa(k.dictLimit), strconv.Itoa(k.quality), strconv.Itoa(t.assets),
+			strconv.Itoa(t.original), strconv.Itoa(t.compressed), strconv.FormatFloat(ratio, 'f', 3, 64), saved,
+			strconv.FormatFloat(throughput(t.original, time.Duration(t.encodeNs)), 'f', 2, 64),
+			strconv.FormatFloat(throughput(t.original, time.Duration(t.decodeNs)), 'f', 2, 64)})
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+/* openSinks creates the outputs requested on the command line, timing tells if decode times are wanted: with -timing or -runs above 1 */
+func openSinks() (sinks []resultSink, timing bool, err error) {
+	timing = *doTiming || *runs > 1
+
+	if *xlsxpath != "" {
+		s, err := newXLSXSink(*xlsxpath)
+		if err != nil {
+			return nil, false, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if *csvpath != "" {
+		s, err := newCSVSink(*csvpath)
+		if err != nil {
+			return nil, false, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if *jsonpath != "" {
+		s, err := newJSONSink(*jsonpath)
+		if err != nil {
+			return nil, false, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if *summarypath != "" {
+		sinks = append(sinks, newSummarySink(*summarypath))
+	}
+
+	if len(sweepSizes) > 0 {
+		sinks = append(sinks, newSweepSink(*sweepReport))
+	}
+
+	return sinks, timing, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCSVAndJSONSinks(t *testing.T) {
+	defer tempDataset(t)()
+	engineDataset(t, 2)
+
+	oldDicts, oldGrid := dictpath, grid
+	defer func() { dictpath, grid = oldDicts, oldGrid }()
+	dictpath = datapath + "no-dicts/"
+
+	grid = &testGrid{
+		Qualities:   map[string][]int{"Deflate": {1, 6}},
+		DictSizes:   []int{1024},
+		compressors: []compressor{&gzipper{}},
+		strategies:  []Strategy{strategyByName("none"), strategyByName("previous")},
+	}
+
+	cs, err := newCSVSink(datapath + "results.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := newJSONSink(datapath + "results.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEngine([]resultSink{cs, js}, false).run(siteDirs(), "")
+	cs.close()
+	js.close()
+
+	f, err := os.Open(datapath + "results.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+
+	f, err = os.Open(datapath + "results.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []result
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var r result
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, r)
+	}
+
+	/* One row per site, asset, compressor, strategy and quality */
+	want := 2 * 3 * 1 * 2 * 2
+	if len(rows)-1 != want || len(lines) != want {
+		t.Fatalf("%d CSV rows and %d JSON lines, want %d", len(rows)-1, len(lines), want)
+	}
+
+	seen := make(map[string]bool)
+	for i, row := range rows[1:] {
+		r := lines[i]
+		key := fmt.Sprintf("%s %s %s %s %s", row[col["site"]], row[col["asset"]], row[col["compressor"]], row[col["strategy"]], row[col["quality"]])
+		if seen[key] {
+			t.Errorf("Duplicate row %s", key)
+		}
+		seen[key] = true
+
+		if key != fmt.Sprintf("%s %d %s %s %d", r.Site, r.Asset, r.Compressor, r.Strategy, r.Quality) {
+			t.Errorf("CSV row %s, JSON line %+v", key, r)
+		}
+		if r.DictType == "" || row[col["dict_type"]] != r.DictType {
+			t.Errorf("%s: CSV dict_type %q, JSON %q", key, row[col["dict_type"]], r.DictType)
+		}
+		if row[col["compressed_size"]] != strconv.Itoa(r.CompressedSize) {
+			t.Errorf("%s: CSV compressed_size %s, JSON %d", key, row[col["compressed_size"]], r.CompressedSize)
+		}
+	}
+}
+
+func TestSummaryBuckets(t *testing.T) {
+	for _, c := range []struct {
+		idx  int
+		want string
+	}{{0, "0"}, {4, "4"}, {5, "5-9"}, {9, "5-9"}, {10, "10-19"}, {19, "10-19"}, {20, "20+"}} {
+		if b := positionBucket(c.idx); b != c.want {
+			t.Errorf("Asset %d in position bucket %s, want %s", c.idx, b, c.want)
+		}
+	}
+	for _, c := range []struct {
+		size int
+		want string
+	}{{0, "<1K"}, {1023, "<1K"}, {1024, "1K-4K"}, {16383, "4K-16K"}, {16384, "16K-64K"}, {256<<10 - 1, "64K-256K"}, {256 << 10, "256K+"}} {
+		if b := sizeBucket(c.size); b != c.want {
+			t.Errorf("%d bytes in size bucket %s, want %s", c.size, b, c.want)
+		}
+	}
+}
+
+func TestSummaryReport(t *testing.T) {
+	f, err := ioutil.TempFile("", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	s := newSummarySink(f.Name())
+	for _, r := range []*result{
+		{ContentType: "text/html", Asset: 4, Compressor: "Brotli", Strategy: "none", Quality: 5, OriginalSize: 1023, CompressedSize: 500},
+		{ContentType: "text/html", Asset: 4, Compressor: "Brotli", Strategy: "static", Quality: 5, OriginalSize: 1023, CompressedSize: 300},
+		{ContentType: "text/html", Asset: 5, Compressor: "Brotli", Strategy: "none", Quality: 5, OriginalSize: 1024, CompressedSize: 600},
+		{ContentType: "text/html", Asset: 5, Compressor: "Brotli", Strategy: "static", Quality: 5, OriginalSize: 1024, CompressedSize: 700},
+		/* Without a reference cell there is nothing to compare to */
+		{ContentType: "text/css", Asset: 0, Compressor: "Brotli", Strategy: "static", Quality: 9, OriginalSize: 100, CompressedSize: 50},
+	} {
+		s.add(r)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	want := []string{
+		"dimension,bucket,compressor,strategy,dict_limit,quality,assets,original_size,compressed_size,ratio,saved_vs_none,encode_mbps,decode_mbps",
+		"content_type,text/css,Brotli,static,0,9,1,100,50,2.000,,0.00,0.00",
+		"content_type,text/html,Brotli,none,0,5,2,2047,1100,1.861,0,0.00,0.00",
+		"content_type,text/html,Brotli,static,0,5,2,2047,1000,2.047,100,0.00,0.00",
+		"position,0,Brotli,static,0,9,1,100,50,2.000,,0.00,0.00",
+		"position,4,Brotli,none,0,5,1,1023,500,2.046,0,0.00,0.00",
+		"position,4,Brotli,static,0,5,1,1023,300,3.410,200,0.00,0.00",
+		"position,5-9,Brotli,none,0,5,1,1024,600,1.707,0,0.00,0.00",
+		"position,5-9,Brotli,static,0,5,1,1024,700,1.463,-100,0.00,0.00",
+		"size,1K-4K,Brotli,none,0,5,1,1024,600,1.707,0,0.00,0.00",
+		"size,1K-4K,Brotli,static,0,5,1,1024,700,1.463,-100,0.00,0.00",
+		"size,<1K,Brotli,none,0,5,1,1023,500,2.046,0,0.00,0.00",
+		"size,<1K,Brotli,static,0,5,1,1023,300,3.410,200,0.00,0.00",
+		"size,<1K,Brotli,static,0,9,1,100,50,2.000,,0.00,0.00",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected summary:\n%s", raw)
+	}
+}
+
+func TestOpenSinksTiming(t *testing.T) {
+	defer tempDataset(t)()
+
+	oldX, oldCSV, oldRuns := *xlsxpath, *csvpath, *runs
+	defer func() { *xlsxpath, *csvpath, *runs, *doTiming = oldX, oldCSV, oldRuns, false }()
+	*xlsxpath, *csvpath = "", datapath+"results.csv"
+
+	/* Asking for a CSV alone keeps every core busy */
+	for _, c := range []struct {
+		runs   int
+		timing bool
+		want   bool
+	}{{1, false, false}, {1, true, true}, {3, false, true}} {
+		*runs, *doTiming = c.runs, c.timing
+		sinks, timing, err := openSinks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range sinks {
+			s.close()
+		}
+		if timing != c.want {
+			t.Errorf("-runs %d -timing=%v: timing %v", c.runs, c.timing, timing)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+)
+
+/* fold is a set of sites to evaluate, with the sites its dictionaries are trained on */
+type fold struct {
+	/* label is what the reports call the fold, empty when the dataset is not split */
+	label   string
+	dictDir string
+	train   []os.FileInfo
+	test    []os.FileInfo
+}
+
+/* siteHash places a site deterministically in [0, 1), the same for a given seed however the dataset grows */
+func siteHash(name string, seed int64) float64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, seed)
+	h.Write([]byte(name))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+/* readSiteList reads a file with a site per line, as given to -w or as the name of its dataset directory */
+func readSiteList(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sites := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sites[siteDirName(line)] = true
+	}
+
+	return sites, scanner.Err()
+}
+
+func datasetSplit() bool {
+	return *trainSites != "" || *testSites != "" || *kFolds > 1 || *holdout > 0
+}
+
+/* splitSites divides the sites according to -train-sites and -test-sites, -folds or -holdout; without any, there is a single fold that trains and tests on every site */
+func splitSites(dirs []os.FileInfo) ([]*fold, error) {
+	listed := *trainSites != "" || *testSites != ""
+	if (listed && (*kFolds > 1 || *holdout > 0)) || (*kFolds > 1 && *holdout > 0) {
+		return nil, errors.New("-train-sites/-test-sites, -folds and -holdout can not be combined")
+	}
+	if *holdout < 0 || *holdout >= 1 {
+		return nil, fmt.Errorf("invalid holdout %g: want a fraction in [0, 1)", *holdout)
+	}
+
+	switch {
+	case listed:
+		var train, test map[string]bool
+		var err error
+		if *trainSites != "" {
+			if train, err = readSiteList(*trainSites); err != nil {
+				return nil, err
+			}
+		}
+		if *testSites != "" {
+			if test, err = readSiteList(*testSites); err != nil {
+				return nil, err
+			}
+		}
+
+		f := &fold{label: "test", dictDir: dictpath}
+		for _, d := range dirs {
+			inTrain := train[d.Name()] || (train == nil && !test[d.Name()])
+			inTest := test[d.Name()] || (test == nil && !train[d.Name()])
+			if inTrain && inTest {
+				return nil, fmt.Errorf("site %s is both in the train and in the test sites", d.Name())
+			}
+			if inTrain {
+				f.train = append(f.train, d)
+			}
+			if inTest {
+				f.test = append(f.test, d)
+			}
+		}
+		return []*fold{f}, nil
+
+	case *kFolds > 1:
+		ret := make([]*fold, *kFolds)
+		for i := range ret {
+			label := fmt.Sprintf("fold-%d", i)
+			ret[i] = &fold{label: label, dictDir: dictpath + label + "/"}
+		}
+		for _, d := range dirs {
+			k := int(siteHash(d.Name(), *splitSeed) * float64(*kFolds))
+			for i, f := range ret {
+				if i == k {
+					f.test = append(f.test, d)
+				} else {
+					f.train = append(f.train, d)
+				}
+			}
+		}
+		return ret, nil
+
+	case *holdout > 0:
+		f := &fold{label: "test", dictDir: dictpath}
+		for _, d := range dirs {
+			if siteHash(d.Name(), *splitSeed) < *holdout {
+				f.test = append(f.test, d)
+			} else {
+				f.train = append(f.train, d)
+			}
+		}
+		return []*fold{f}, nil
+	}
+
+	return []*fold{{dictDir: dictpath, train: dirs, test: dirs}}, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func splitDataset(t *testing.T, n int) []os.FileInfo {
+	for i := 0; i < n; i++ {
+		if err := os.Mkdir(fmt.Sprintf("%ssite%d.com", datapath, i), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return siteDirs()
+}
+
+func names(dirs []os.FileInfo) map[string]bool {
+	ret := make(map[string]bool)
+	for _, d := range dirs {
+		ret[d.Name()] = true
+	}
+	return ret
+}
+
+func TestSplitFolds(t *testing.T) {
+	defer tempDataset(t)()
+	dirs := splitDataset(t, 100)
+
+	*kFolds = 4
+	defer func() { *kFolds = 1 }()
+
+	folds, err := splitSites(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folds) != 4 {
+		t.Fatalf("%d folds, want 4", len(folds))
+	}
+
+	tested := make(map[string]int)
+	for _, f := range folds {
+		if len(f.train)+len(f.test) != len(dirs) {
+			t.Errorf("%s: %d train and %d test sites, want %d in total", f.label, len(f.train), len(f.test), len(dirs))
+		}
+		train := names(f.train)
+		for _, d := range f.test {
+			if train[d.Name()] {
+				t.Errorf("%s: %s is trained and tested on", f.label, d.Name())
+			}
+			tested[d.Name()]++
+		}
+	}
+	for _, d := range dirs {
+		if tested[d.Name()] != 1 {
+			t.Errorf("%s is tested in %d folds, want 1", d.Name(), tested[d.Name()])
+		}
+	}
+
+	again, _ := splitSites(dirs)
+	for i := range folds {
+		if fmt.Sprint(names(folds[i].test)) != fmt.Sprint(names(again[i].test)) {
+			t.Errorf("%s differs between runs", folds[i].label)
+		}
+	}
+}
+
+func TestSplitHoldout(t *testing.T) {
+	defer tempDataset(t)()
+	dirs := splitDataset(t, 200)
+
+	*holdout = 0.25
+	defer func() { *holdout = 0 }()
+
+	folds, err := splitSites(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := folds[0]
+	if len(f.test) < 30 || len(f.test) > 70 {
+		t.Errorf("%d of %d sites held out, want about a quarter", len(f.test), len(dirs))
+	}
+	if len(f.train)+len(f.test) != len(dirs) {
+		t.Errorf("%d train and %d test sites, want %d in total", len(f.train), len(f.test), len(dirs))
+	}
+}
+
+func TestSplitLists(t *testing.T) {
+	defer tempDataset(t)()
+	dirs := splitDataset(t, 5)
+
+	list := datapath + ".test-sites"
+	ioutil.WriteFile(list, []byte("# held out\nsite1.com\nsite3.com\n"), 0644)
+	*testSites = list
+	defer func() { *testSites = "" }()
+
+	folds, err := splitSites(dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test, train := names(folds[0].test), names(folds[0].train)
+	if len(test) != 2 || !test["site1.com"] || !test["site3.com"] || len(train) != 3 || train["site1.com"] {
+		t.Errorf("got test %v and train %v", test, train)
+	}
+
+	*kFolds = 3
+	defer func() { *kFolds = 1 }()
+	if _, err := splitSites(dirs); err == nil {
+		t.Error("expected an error combining -test-sites and -folds")
+	}
+}
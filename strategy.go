@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* dictSelector picks the dictionary for the next asset of a site, and where it came from; it is called once per asset, in order */
+type dictSelector func(u *asset) (dict []byte, source string)
+
+/* Strategy decides which dictionary each asset of a site is compressed with */
+type Strategy interface {
+	Name() string
+	Description() string
+	/* Selector starts a new site compressed with c, dynamic dictionaries are limited to size bytes */
+	Selector(c compressor, size int) dictSelector
+}
+
+/* funcStrategy is a Strategy made of a selector constructor */
+type funcStrategy struct {
+	name        string
+	description string
+	selector    func(c compressor, size int) dictSelector
+}
+
+func (s *funcStrategy) Name() string {
+	return s.name
+}
+
+func (s *funcStrategy) Description() string {
+	return s.description
+}
+
+func (s *funcStrategy) Selector(c compressor, size int) dictSelector {
+	return s.selector(c, size)
+}
+
+/* The reference strategy the summary compares every other strategy with */
+const referenceStrategy = "none"
+
+/* Strategies are kept in registration order, which is the default order of the reports */
+var strategies []Strategy
+
+func registerStrategy(s Strategy) {
+	if strategyByName(s.Name()) != nil {
+		panic("strategy " + s.Name() + " registered twice")
+	}
+	strategies = append(strategies, s)
+}
+
+func strategyByName(name string) Strategy {
+	for _, s := range strategies {
+		if strings.EqualFold(s.Name(), name) {
+			return s
+		}
+	}
+	return nil
+}
+
+/* strategyIndex is the registration order of a strategy, -1 if there is no such strategy */
+func strategyIndex(name string) int {
+	for i, s := range strategies {
+		if s.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+/* strategyNames lists the registered strategies, sorted, for error messages */
+func strategyNames() string {
+	names := make([]string, 0, len(strategies))
+	for _, s := range strategies {
+		names = append(names, s.Name())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func listStrategies() {
+	for _, s := range strategies {
+		fmt.Printf("%-16s %s\n", s.Name(), s.Description())
+	}
+}
+
+/* runStrategy compresses the assets of a site in order, each with the dictionary the strategy selects */
+func runStrategy(s Strategy, list []*asset, c compressor, quality, size int) []assetResult {
+	ret := make([]assetResult, 0, len(list))
+	selectDict := s.Selector(c, size)
+
+	for _, u := range list {
+		dict, source := selectDict(u)
+		ret = append(ret, compressAsset(c, u, dict, source, quality))
+	}
+
+	return ret
+}
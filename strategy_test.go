@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrategyRegistry(t *testing.T) {
+	if s := strategyByName("Static-Rolling"); s == nil || s.Name() != "static-rolling" {
+		t.Errorf("Lookup should ignore case, got %v", s)
+	}
+	if strategyIndex("none") != 0 {
+		t.Errorf("none should be registered first")
+	}
+
+	/* Names differing only in case are the same strategy */
+	func() {
+		n := len(strategies)
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Registering a strategy twice should panic")
+			}
+			if len(strategies) != n {
+				t.Errorf("The duplicate was registered")
+			}
+		}()
+		registerStrategy(&funcStrategy{"NONE", "Again", strategyNone})
+	}()
+
+	*gridStrategies = "none,no-such-strategy"
+	defer func() { *gridStrategies = "" }()
+	_, err := newGrid("", map[string]bool{"strategies": true})
+	if err == nil || !strings.Contains(err.Error(), "no-such-strategy") || !strings.Contains(err.Error(), strategyNames()) {
+		t.Errorf("Unknown strategies should be reported with the known ones: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* sweepSizes are the dictionary sizes of -sweep, empty without it */
+var sweepSizes []int
+
+/* sweepStrategies are evaluated for every size of a sweep, unless -strategies says otherwise */
+var sweepStrategies = []string{referenceStrategy, "static", "static-dynamic", "static-rolling"}
+
+/* staticDictDir is where the static dictionaries of a size are: side by side in <dicts>/size-<n>/ when sweeping */
+func staticDictDir(dir string, size int) string {
+	if len(sweepSizes) == 0 {
+		return dir
+	}
+	return fmt.Sprintf("%ssize-%d/", dir, size)
+}
+
+/* sweepSink totals the bytes saved by every strategy and dictionary size, per dictionary type */
+type sweepSink struct {
+	path   string
+	totals map[sweepKey]*summaryTotal
+}
+
+type sweepKey struct {
+	dictType, compressor, strategy string
+	quality, size                  int
+}
+
+func newSweepSink(path string) *sweepSink {
+	return &sweepSink{path: path, totals: make(map[sweepKey]*summaryTotal)}
+}
+
+func (s *sweepSink) add(r *result) error {
+	key := sweepKey{dictType: r.DictType, compressor: r.Compressor, strategy: r.Strategy, quality: r.Quality, size: r.DictLimit}
+	t, ok := s.totals[key]
+	if !ok {
+		t = &summaryTotal{}
+		s.totals[key] = t
+	}
+	t.assets++
+	t.original += r.OriginalSize
+	t.compressed += r.CompressedSize
+	return nil
+}
+
+func (s *sweepSink) flush() error {
+	return nil
+}
+
+/* saved is how many bytes a cell saves over the reference strategy at the same size, false without a reference */
+func (s *sweepSink) saved(k sweepKey) (int, bool) {
+	ref := k
+	ref.strategy = referenceStrategy
+	r, ok := s.totals[ref]
+	if !ok {
+		return 0, false
+	}
+	return r.compressed - s.totals[k].compressed, true
+}
+
+func (s *sweepSink) keys() []sweepKey {
+	keys := make([]sweepKey, 0, len(s.totals))
+	for k := range s.totals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.dictType != b.dictType {
+			return a.dictType < b.dictType
+		}
+		if a.compressor != b.compressor {
+			return a.compressor < b.compressor
+		}
+		if a.quality != b.quality {
+			return a.quality < b.quality
+		}
+		if a.strategy != b.strategy {
+			return strategyIndex(a.strategy) < strategyIndex(b.strategy)
+		}
+		return a.size < b.size
+	})
+	return keys
+}
+
+/* The report is written at the end, as CSV and as a plot of bytes saved against dictionary size on stdout */
+func (s *sweepSink) close() error {
+	keys := s.keys()
+
+	f, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"dict_type", "compressor", "quality", "strategy", "dict_size", "assets", "original_size", "compressed_size", "saved_vs_" + referenceStrategy})
+	for _, k := range keys {
+		t := s.totals[k]
+		saved := ""
+		if n, ok := s.saved(k); ok {
+			saved = strconv.Itoa(n)
+		}
+		w.Write([]string{k.dictType, k.compressor, strconv.Itoa(k.quality), k.strategy, strconv.Itoa(k.size),
+			strconv.Itoa(t.assets), strconv.Itoa(t.original), strconv.Itoa(t.compressed), saved})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	s.plot(keys)
+	return nil
+}
+
+const sweepPlotWidth = 50
+
+/* plot draws a bar per size for every series, scaled to the largest saving of the dictionary type */
+func (s *sweepSink) plot(keys []sweepKey) {
+	best := make(map[string]int)
+	for _, k := range keys {
+		if n, ok := s.saved(k); ok && n > best[k.dictType] {
+			best[k.dictType] = n
+		}
+	}
+
+	dictType, series := "", ""
+	for _, k := range keys {
+		if k.strategy == referenceStrategy {
+			continue
+		}
+		n, ok := s.saved(k)
+		if !ok {
+			continue
+		}
+
+		if k.dictType != dictType {
+			dictType, series = k.dictType, ""
+			fmt.Printf("\nBytes saved vs %s for %s\n", referenceStrategy, dictType)
+		}
+		if name := fmt.Sprintf("%s %d, %s", k.compressor, k.quality, k.strategy); name != series {
+			series = name
+			fmt.Printf("  %s\n", series)
+		}
+
+		bar := 0
+		if n > 0 && best[k.dictType] > 0 {
+			bar = n * sweepPlotWidth / best[k.dictType]
+		}
+		fmt.Printf("  %8d %-*s %d\n", k.size, sweepPlotWidth, strings.Repeat("#", bar), n)
+	}
+}
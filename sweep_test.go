@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSweepGrid(t *testing.T) {
+	sweepSizes = []int{16384, 65536}
+	defer func() { sweepSizes = nil }()
+
+	g, err := newGrid("", map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.DictSizes) != 2 || strings.Join(g.Strategies, ",") != "none,static,static-dynamic,static-rolling" {
+		t.Errorf("Unexpected sweep grid %v %v", g.DictSizes, g.Strategies)
+	}
+	if dir := staticDictDir("./dicts/fold-1/", 65536); dir != "./dicts/fold-1/size-65536/" {
+		t.Errorf("Unexpected dictionary directory %s", dir)
+	}
+
+	*gridDictSizes = "1024"
+	defer func() { *gridDictSizes = "" }()
+	if _, err := newGrid("", map[string]bool{"dict-sizes": true}); err == nil {
+		t.Errorf("-dict-sizes should conflict with -sweep")
+	}
+}
+
+func TestSweepReport(t *testing.T) {
+	f, err := ioutil.TempFile("", "sweep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	s := newSweepSink(f.Name())
+	for _, size := range []int{16384, 32768} {
+		for _, r := range []*result{
+			{DictType: "text/html", Compressor: "Brotli", Strategy: "none", Quality: 5, DictLimit: size, OriginalSize: 1000, CompressedSize: 400},
+			{DictType: "text/html", Compressor: "Brotli", Strategy: "static", Quality: 5, DictLimit: size, OriginalSize: 1000, CompressedSize: 400 - size/1024},
+			{DictType: "text/html", Compressor: "Brotli", Strategy: "static", Quality: 5, DictLimit: size, OriginalSize: 500, CompressedSize: 200},
+			{DictType: "text/html", Compressor: "Brotli", Strategy: "none", Quality: 5, DictLimit: size, OriginalSize: 500, CompressedSize: 200},
+		} {
+			s.add(r)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	want := []string{
+		"dict_type,compressor,quality,strategy,dict_size,assets,original_size,compressed_size,saved_vs_none",
+		"text/html,Brotli,5,none,16384,2,1500,600,0",
+		"text/html,Brotli,5,none,32768,2,1500,600,0",
+		"text/html,Brotli,5,static,16384,2,1500,584,16",
+		"text/html,Brotli,5,static,32768,2,1500,568,32",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected report:\n%s", raw)
+	}
+}
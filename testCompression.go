@@ -4,17 +4,19 @@ import (
 	"./bro"
 	"bytes"
 	"compress/flate"
-	"fmt"
-	"github.com/tealeg/xlsx"
 	"io/ioutil"
 	"log"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
 )
 
 type compressor interface {
 	String() string
 	CompressWithDict([]byte, []byte, int) []byte
+	DecompressWithDict([]byte, []byte) ([]byte, error)
+	/* One-time cost of preparing the encoder with a dictionary, before any data is compressed */
+	LoadDictTime([]byte, int) time.Duration
+	QualityRange() (int, int)
 }
 
 type gzipper struct {
@@ -23,7 +25,7 @@ type gzipper struct {
 type brotler struct {
 }
 
-var compressors []compressor = []compressor{&gzipper{}, &brotler{}}
+var compressors []compressor = []compressor{&gzipper{}, &brotler{}, &zstder{}, &dcber{}, &dczer{}}
 
 func (c *brotler) String() string {
 	return "Brotli"
@@ -33,6 +35,14 @@ func (c *gzipper) String() string {
 	return "Deflate"
 }
 
+func (c *brotler) QualityRange() (int, int) {
+	return 0, 11
+}
+
+func (c *gzipper) QualityRange() (int, int) {
+	return flate.HuffmanOnly, flate.BestCompression
+}
+
 func (c *brotler) CompressWithDict(in, dict []byte, quality int) []byte {
 	brot := bro.Encoder()
 
@@ -58,136 +68,192 @@ func (c *gzipper) CompressWithDict(in, dict []byte, quality int) []byte {
 	return b.Bytes()
 }
 
-var strategies []func([]*asset, compressor, int) int
+func (c *brotler) LoadDictTime(dict []byte, quality int) time.Duration {
+	brot := bro.Encoder()
 
-func init() {
-	strategies = make([]func([]*asset, compressor, int) int, 0)
-	strategies = append(strategies, strategy0)
-	strategies = append(strategies, strategy1)
-	strategies = append(strategies, strategy2)
-	strategies = append(strategies, strategy3)
-	strategies = append(strategies, strategy4)
-	strategies = append(strategies, strategy5)
-	strategies = append(strategies, strategy6)
-	strategies = append(strategies, strategy7)
+	start := time.Now()
+	brot.SetDict(dict, quality)
+	elapsed := time.Since(start)
+
+	/* Compress releases the encoder */
+	brot.Compress(quality, []byte{})
+	return elapsed
 }
 
-/* This one is the reference: simply compress */
-func strategy0(list []*asset, c compressor, quality int) int {
-	ret := 0
+func (c *gzipper) LoadDictTime(dict []byte, quality int) time.Duration {
+	var b bytes.Buffer
 
-	for _, u := range list {
-		ret += len(c.CompressWithDict(u.content, nil, quality))
+	start := time.Now()
+	flate.NewWriterDict(&b, quality, dict)
+	return time.Since(start)
+}
+
+func (c *brotler) DecompressWithDict(in, dict []byte) ([]byte, error) {
+	r, err := bro.NewReaderDict(bytes.NewReader(in), dict)
+	if err != nil {
+		return nil, err
 	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
 
-	return ret
+func (c *gzipper) DecompressWithDict(in, dict []byte) ([]byte, error) {
+	r := flate.NewReaderDict(bytes.NewReader(in), dict)
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+/* assetResult is what a strategy did with a single asset */
+type assetResult struct {
+	asset *asset
+	/* Where the dictionary came from, "none" if there was no dictionary */
+	dictSource string
+	dict       []byte
+	compressed []byte
+	encodeTime timings
+}
+
+func compressAsset(c compressor, u *asset, dict []byte, source string, quality int) assetResult {
+	if dict == nil {
+		source = "none"
+	}
+
+	var out []byte
+	t := measure(*runs, func() {
+		out = c.CompressWithDict(u.content, dict, quality)
+	})
+	return assetResult{asset: u, dictSource: source, dict: dict, compressed: out, encodeTime: t}
+}
+
+func init() {
+	registerStrategy(&funcStrategy{"none", "No dictionary, the reference", strategyNone})
+	registerStrategy(&funcStrategy{"first", "The first asset of the site", strategyFirst})
+	registerStrategy(&funcStrategy{"previous", "The previous asset", strategyPrevious})
+	registerStrategy(&funcStrategy{"concat", "The concatenation of all previous assets", strategyConcat})
+	registerStrategy(&funcStrategy{"same-type", "The last asset with the same content type, otherwise the first asset", strategySameType})
+	registerStrategy(&funcStrategy{"static", "The static dictionary of the content type", strategyStatic})
+	registerStrategy(&funcStrategy{"static-dynamic", "The static dictionary of the content type, then the last asset with that type", strategyStaticDynamic})
+	registerStrategy(&funcStrategy{"static-rolling", "The static dictionary of the content type, rolled with every following asset", strategyStaticRolling})
+	registerStrategy(&funcStrategy{"transport", "Compression Dictionary Transport: earlier responses advertised with Use-As-Dictionary", strategyTransport})
+}
+
+/* This one is the reference: simply compress */
+func strategyNone(c compressor, size int) dictSelector {
+	return func(u *asset) ([]byte, string) {
+		return nil, "none"
+	}
 }
 
 /* Use the first stream, always */
-func strategy1(list []*asset, c compressor, quality int) int {
-	ret := 0
+func strategyFirst(c compressor, size int) dictSelector {
 	var dict []byte
 
-	for _, u := range list {
-		ret += len(c.CompressWithDict(u.content, dict, quality))
-
+	return func(u *asset) ([]byte, string) {
+		ret := dict
 		if dict == nil {
-			if len(u.content) > dictSize {
-				dict = u.content[:dictSize]
-			} else {
-				dict = u.content
-			}
+			dict = toDictSize(u.content, size)
 		}
+		return ret, "first"
 	}
-
-	return ret
 }
 
 /* Use the previous stream, always */
-func strategy2(list []*asset, c compressor, quality int) int {
-	ret := 0
+func strategyPrevious(c compressor, size int) dictSelector {
 	var dict []byte
 
-	for _, u := range list {
-		ret += len(c.CompressWithDict(u.content, dict, quality))
-
-		if len(u.content) > dictSize {
-			dict = u.content[:dictSize]
-		} else {
-			dict = u.content
-		}
+	return func(u *asset) ([]byte, string) {
+		ret := dict
+		dict = toDictSize(u.content, size)
+		return ret, "previous"
 	}
-
-	return ret
 }
 
-func toDictSize(in []byte) []byte {
-	if len(in) > dictSize {
-		return in[:dictSize]
+/* The capacity is capped, so appending to a dictionary never writes into the asset it was cut from */
+func toDictSize(in []byte, size int) []byte {
+	if len(in) > size {
+		return in[:size:size]
 	} else {
-		return in
+		return in[:len(in):len(in)]
 	}
 }
 
-func toDictSizeFromEnd(in []byte) []byte {
-	if len(in) > dictSize {
-		return in[len(in)-dictSize:]
+func toDictSizeFromEnd(in []byte, size int) []byte {
+	if len(in) > size {
+		return in[len(in)-size:]
 	} else {
-		return in
+		return in[:len(in):len(in)]
 	}
 }
 
 /* Use the concatenation of all previous streams as dictionary */
-func strategy3(list []*asset, c compressor, quality int) int {
-	ret := 0
+func strategyConcat(c compressor, size int) dictSelector {
 	var dict []byte
 
-	for _, u := range list {
-		ret += len(c.CompressWithDict(u.content, dict, quality))
-
+	return func(u *asset) ([]byte, string) {
+		ret := dict
 		if dict == nil {
-			dict = toDictSize(u.content)
+			dict = toDictSize(u.content, size)
 		} else {
-			dict = toDictSizeFromEnd(append(dict, toDictSize(u.content)...))
+			dict = toDictSizeFromEnd(append(dict, toDictSize(u.content, size)...), size)
 		}
+		return ret, "concat"
 	}
-
-	return ret
 }
 
 /* Use last stream with the same content type as dictionary, otherwise use the first stream */
-func strategy4(list []*asset, c compressor, quality int) int {
-	ret := 0
+func strategySameType(c compressor, size int) dictSelector {
 	dicts := make(map[string][]byte)
 	var firstDict []byte
 
-	for _, u := range list {
+	return func(u *asset) ([]byte, string) {
 		var dict []byte
+		source := "none"
 
 		if firstDict == nil {
-			firstDict = toDictSize(u.content)
-		} else if getDict, ok := dicts[u.contentType]; ok {
+			firstDict = toDictSize(u.content, size)
+		} else if getDict, ok := dicts[u.dictType()]; ok {
 			dict = getDict
+			source = "same-type"
 		} else {
 			dict = firstDict
+			source = "first"
 		}
 
-		dicts[u.contentType] = toDictSize(u.content)
-
-		ret += len(c.CompressWithDict(u.content, dict, quality))
+		dicts[u.dictType()] = toDictSize(u.content, size)
+		return dict, source
 	}
+}
+
+var staticDicts = make(map[string]map[string][]byte)
+var staticDictsLock sync.Mutex
 
-	return ret
+/* dictSuffix is the extension of the static dictionaries c uses: ZDICT ones for zstd with -zdict, dictator ones otherwise */
+func dictSuffix(c compressor) string {
+	if _, ok := c.(*zstder); ok && *useZDict {
+		return ".zdict"
+	}
+	return ".dict"
 }
 
-func openDicts() map[string][]byte {
-	dicts, _ := ioutil.ReadDir(dictpath)
+/* openDicts reads the dictionaries for c, and a size when sweeping, once; it returns a copy of the map the strategy is free to modify */
+func openDicts(c compressor, size int) map[string][]byte {
+	suffix := dictSuffix(c)
+	dir := staticDictDir(dictpath, size)
+
+	key := dir + suffix + "@" + *dictVersion
 
-	dictByType := make(map[string][]byte)
+	staticDictsLock.Lock()
+	loaded, ok := staticDicts[key]
+	if !ok {
+		loaded = loadDicts(dir, suffix)
+		staticDicts[key] = loaded
+	}
+	staticDictsLock.Unlock()
 
-	for _, d := range dicts {
-		dict, _ := ioutil.ReadFile(dictpath + d.Name())
-		ct := strings.TrimSuffix(strings.Replace(d.Name(), "__", "/", -1), ".dict")
+	dictByType := make(map[string][]byte, len(loaded))
+	for ct, dict := range loaded {
 		dictByType[ct] = dict
 	}
 
@@ -195,108 +261,84 @@ func openDicts() map[string][]byte {
 }
 
 /* Use content type based static dictionary */
-func strategy5(list []*asset, c compressor, quality int) int {
-	ret := 0
-	dicts := openDicts()
-
-	for _, u := range list {
-		var dict []byte
-
-		if d, ok := dicts[u.contentType]; ok {
-			dict = d
-		}
+func strategyStatic(c compressor, size int) dictSelector {
+	dicts := openDicts(c, size)
 
-		ret += len(c.CompressWithDict(u.content, dict, quality))
+	return func(u *asset) ([]byte, string) {
+		return dicts[u.dictType()], "static"
 	}
-
-	return ret
 }
 
 /* Use content type based static + dynamic dictionary */
-func strategy6(list []*asset, c compressor, quality int) int {
-	ret := 0
-	dicts := openDicts()
-
-	for _, u := range list {
-		var dict []byte
-
-		if d, ok := dicts[u.contentType]; ok {
-			dict = d
+func strategyStaticDynamic(c compressor, size int) dictSelector {
+	dicts := openDicts(c, size)
+	sources := make(map[string]string)
+
+	return func(u *asset) ([]byte, string) {
+		dict := dicts[u.dictType()]
+		source := "static"
+		if s, ok := sources[u.dictType()]; ok {
+			source = s
 		}
 
-		ret += len(c.CompressWithDict(u.content, dict, quality))
-
-		dicts[u.contentType] = toDictSize(u.content)
+		dicts[u.dictType()] = toDictSize(u.content, size)
+		sources[u.dictType()] = "same-type"
+		return dict, source
 	}
-	return ret
 }
 
 /* Use content type based static+dynamic "rolling" dictionary */
-func strategy7(list []*asset, c compressor, quality int) int {
-	dicts := openDicts()
+func strategyStaticRolling(c compressor, size int) dictSelector {
+	dicts := openDicts(c, size)
+	rolled := make(map[string]bool)
 	var dict []byte
-	ret := 0
+	source := "rolling"
 
-	for _, u := range list {
-
-		if d, ok := dicts[u.contentType]; ok {
+	return func(u *asset) ([]byte, string) {
+		if d, ok := dicts[u.dictType()]; ok {
 			dict = d
+			if source = "static"; rolled[u.dictType()] {
+				source = "rolling"
+			}
 		}
+		ret, retSource := dict, source
 
-		ret += len(c.CompressWithDict(u.content, dict, quality))
-
-		dict = toDictSizeFromEnd(append(dict, toDictSize(u.content)...))
-		dicts[u.contentType] = dict
+		dict = toDictSizeFromEnd(append(dict, toDictSize(u.content, size)...), size)
+		dicts[u.dictType()] = dict
+		rolled[u.dictType()] = true
+		source = "rolling"
+		return ret, retSource
 	}
-	return ret
 }
 
-func testStrategy() {
-	/* For each compression algorithm and each stratgy we will have own sheet */
-	file := xlsx.NewFile()
-	sheets := make([]*xlsx.Sheet, len(strategies)*len(compressors))
-
-	for i, c := range compressors {
-		for j, _ := range strategies {
-			sheets[i*len(strategies)+j], _ = file.AddSheet(fmt.Sprintf("%s, S%d", c.String(), j))
-			row := sheets[i*len(strategies)+j].AddRow()
-			row.AddCell().Value = "Website"
-			for q := 4; q <= 8; q++ {
-				row.AddCell().Value = "Quality" + strconv.Itoa(q)
-			}
-		}
+/* testStrategy evaluates the sites of every fold, with the dictionaries trained for it */
+func testStrategy(folds []*fold) {
+	sinks, timing, err := openSinks()
+	if err != nil {
+		log.Println(err)
+		return
 	}
 
-	dirs, _ := ioutil.ReadDir(datapath)
-	for _, d := range dirs {
-		man := parseManifest(datapath + d.Name() + "/manifest")
+	e := newEngine(sinks, timing)
+	defaultPath := dictpath
+	for _, f := range folds {
+		dictpath = f.dictDir
+		e.run(f.test, f.label)
+	}
+	dictpath = defaultPath
 
-		if len(man) <= *skip {
-			continue
+	for _, sink := range sinks {
+		if err := sink.close(); err != nil {
+			log.Println(err)
 		}
+	}
 
-		for _, m := range man {
-			content, err := ioutil.ReadFile(datapath + d.Name() + "/" + strconv.Itoa(m.idx))
-			if err != nil {
-				log.Print(err)
-			}
-			m.content = content
+	if *doVerify {
+		if len(e.failures) == 0 {
+			log.Println("Verification passed")
 		}
-
-		for i, c := range compressors {
-			for j, s := range strategies {
-
-				workingSheet := sheets[i*len(strategies)+j]
-				row := workingSheet.AddRow()
-				row.AddCell().Value = d.Name()
-
-				for quality := 4; quality <= 8; quality++ {
-					log.Println(d.Name(), quality, c)
-					res := s(man, c, quality)
-					row.AddCell().SetInt(res)
-				}
-			}
+		for k, n := range e.failures {
+			log.Printf("Verification: %d failed assets for %s", n, k)
 		}
-		file.Save(*xlsxpath)
 	}
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+/* timings holds repeated measurements of the same operation */
+type timings []time.Duration
+
+func measure(runs int, f func()) timings {
+	if runs < 1 {
+		runs = 1
+	}
+
+	t := make(timings, runs)
+	for i := range t {
+		start := time.Now()
+		f()
+		t[i] = time.Since(start)
+	}
+	return t
+}
+
+/* percentile uses the nearest rank method, p is in [0, 1] */
+func (t timings) percentile(p float64) time.Duration {
+	if len(t) == 0 {
+		return 0
+	}
+
+	sorted := make(timings, len(t))
+	copy(sorted, t)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+/* minus takes a fixed cost out of every measurement, without going below zero */
+func (t timings) minus(d time.Duration) timings {
+	ret := make(timings, len(t))
+	for i := range t {
+		if ret[i] = t[i] - d; ret[i] < 0 {
+			ret[i] = 0
+		}
+	}
+	return ret
+}
+
+func (t timings) median() time.Duration {
+	return t.percentile(0.5)
+}
+
+func (t timings) p90() time.Duration {
+	return t.percentile(0.9)
+}
+
+/* throughput in MB/s of processing size bytes in d */
+func throughput(size int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(size) / (1 << 20) / d.Seconds()
+}
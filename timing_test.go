@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimings(t *testing.T) {
+	ts := timings{5 * time.Millisecond, 1 * time.Millisecond, 3 * time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}
+	if ts.median() != 3*time.Millisecond || ts.p90() != 5*time.Millisecond {
+		t.Errorf("Unexpected median %v or p90 %v", ts.median(), ts.p90())
+	}
+
+	/* The encode time without the dictionary load */
+	less := ts.minus(2 * time.Millisecond)
+	if less.median() != time.Millisecond || less[1] != 0 || ts[1] != time.Millisecond {
+		t.Errorf("Unexpected timings %v", less)
+	}
+}
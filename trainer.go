@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/vkrasnov/dictator"
+)
+
+/* trainer builds the shared dictionary of a content type from sample files */
+type trainer interface {
+	String() string
+	/* params are the settings of the trainer recorded with its dictionaries */
+	params() map[string]string
+	train(paths []string, size int) ([]byte, error)
+}
+
+func newTrainer(name string) (trainer, error) {
+	switch name {
+	case "dictator":
+		return &dictatorTrainer{tableSize: *dictatorTable, workers: *dictatorWorkers, threshold: *dictatorThreshold}, nil
+	case "cover":
+		return &coverTrainer{k: *coverK, d: *coverD, maxBytes: *trainMax}, nil
+	case "freq":
+		return &freqTrainer{length: *freqLength, threshold: *freqThreshold, maxBytes: *trainMax}, nil
+	}
+	return nil, fmt.Errorf("unknown trainer %q, want dictator, cover or freq", name)
+}
+
+/* samplePaths picks files spread evenly over paths until they add up to max bytes, in their original order; 0 keeps them all */
+func samplePaths(paths []string, max int) ([]string, error) {
+	sizes := make([]int64, len(paths))
+	var total int64
+	for i, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = fi.Size()
+		total += sizes[i]
+	}
+	if max <= 0 || total <= int64(max) {
+		return paths, nil
+	}
+
+	/* Every step-th file first, then the ones in between while there is room, so the sample spans the whole set */
+	step := int((total + int64(max) - 1) / int64(max))
+	budget := int64(max)
+	picked := make([]bool, len(paths))
+	for start := 0; start < step; start++ {
+		for i := start; i < len(paths); i += step {
+			if sizes[i] <= budget {
+				budget -= sizes[i]
+				picked[i] = true
+			}
+		}
+	}
+
+	ret := make([]string, 0)
+	for i, path := range paths {
+		if picked[i] {
+			ret = append(ret, path)
+		}
+	}
+	log.Printf("Training on %d of %d files, %d of %d bytes (-train-max)", len(ret), len(paths), int64(max)-budget, total)
+	return ret, nil
+}
+
+/* readSamples reads the files to train on, at most max bytes of them */
+func readSamples(paths []string, max int) ([][]byte, error) {
+	paths, err := samplePaths(paths, max)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		sample, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+/* minSamples is how many samples threshold percent of n is, at least one */
+func minSamples(n int, threshold float64) int {
+	return int(math.Max(1, math.Ceil(float64(n)*threshold/100)))
+}
+
+/* dictatorTrainer keeps the strings deflate fails to compress well, the original trainer */
+type dictatorTrainer struct {
+	/* Size of the substring table, 0 for half the dictionary */
+	tableSize int
+	workers   int
+	/* Percentage of the files a string must appear in */
+	threshold float64
+}
+
+func (t *dictatorTrainer) String() string {
+	return "dictator"
+}
+
+func (t *dictatorTrainer) params() map[string]string {
+	return map[string]string{"table_size": fmt.Sprint(t.tableSize), "threshold": fmt.Sprint(t.threshold)}
+}
+
+func (t *dictatorTrainer) train(paths []string, size int) ([]byte, error) {
+	tableSize := t.tableSize
+	if tableSize <= 0 {
+		tableSize = size / 2
+	}
+
+	progress := make(chan float64, len(paths))
+	go func() {
+		for percent := range progress {
+			fmt.Printf("\r%.2f%% ", percent)
+		}
+	}()
+	table := dictator.GenerateTable(tableSize, paths, DeflateCompressionLevel, progress, t.workers)
+	fmt.Println("\r100%  ")
+	fmt.Println("Total incompressible strings found: ", len(table))
+
+	return []byte(dictator.GenerateDictionary(table, size, minSamples(len(paths), t.threshold))), nil
+}
+
+/* coverTrainer follows the COVER algorithm of zstd, with k-byte segments scored by their distinct d-byte substrings (dmers) */
+type coverTrainer struct {
+	k int
+	d int
+	/* Memory grows with the samples, which are capped at maxBytes */
+	maxBytes int
+}
+
+func (t *coverTrainer) String() string {
+	return "cover"
+}
+
+func (t *coverTrainer) params() map[string]string {
+	return map[string]string{"k": fmt.Sprint(t.k), "d": fmt.Sprint(t.d), "max_bytes": fmt.Sprint(t.maxBytes)}
+}
+
+func (t *coverTrainer) dmer(b []byte) uint64 {
+	var buf [8]byte
+	copy(buf[:], b[:t.d])
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+type coverSegment struct {
+	start, end int
+	score      int
+}
+
+func (t *coverTrainer) train(paths []string, size int) ([]byte, error) {
+	if t.d < 4 || t.d > 8 || t.k < t.d {
+		return nil, fmt.Errorf("invalid cover parameters k=%d d=%d: want 4 <= d <= 8 and k >= d", t.k, t.d)
+	}
+
+	samples, err := readSamples(paths, t.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Frequency is the number of samples a dmer appears in, dmers never cross samples */
+	var corpus []byte
+	var dmers []uint64
+	var offsets []int
+	freq := make(map[uint64]int)
+	for _, sample := range samples {
+		seen := make(map[uint64]bool)
+		for i := 0; i+t.d <= len(sample); i++ {
+			h := t.dmer(sample[i:])
+			dmers = append(dmers, h)
+			offsets = append(offsets, len(corpus)+i)
+			if !seen[h] {
+				seen[h] = true
+				freq[h]++
+			}
+		}
+		corpus = append(corpus, sample...)
+	}
+	if len(dmers) == 0 {
+		return nil, errors.New("not enough data to train")
+	}
+
+	/*
+	   The samples are split into one epoch per segment of the dictionary, and each epoch contributes
+	   the segment whose distinct dmers appear in the most samples. Once picked, a dmer no longer
+	   counts, and the best segments end up last in the dictionary, where references are the cheapest.
+	*/
+	epochs := size / t.k
+	if epochs < 1 {
+		epochs = 1
+	}
+	epochSize := len(dmers) / epochs
+	if epochSize < t.k {
+		epochSize = t.k
+		if epochSize > len(dmers) {
+			epochSize = len(dmers)
+		}
+		epochs = len(dmers) / epochSize
+	}
+
+	segments := make([]coverSegment, 0, epochs)
+	for e := 0; e < epochs; e++ {
+		best := t.bestSegment(dmers, freq, e*epochSize, (e+1)*epochSize)
+		if best.score == 0 {
+			continue
+		}
+		for _, h := range dmers[best.start:best.end] {
+			freq[h] = 0
+		}
+		segments = append(segments, best)
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score < segments[j].score
+	})
+
+	dict := make([]byte, 0, size)
+	for i := len(segments) - 1; i >= 0 && len(dict) < size; i-- {
+		s := segments[i]
+		segment := corpus[offsets[s.start] : offsets[s.end-1]+t.d]
+		if room := size - len(dict); len(segment) > room {
+			segment = segment[len(segment)-room:]
+		}
+		dict = append(segment[:len(segment):len(segment)], dict...)
+	}
+
+	return dict, nil
+}
+
+/* bestSegment slides a window of k-d+1 dmers over [from, to), scoring each window by the frequency of its distinct dmers */
+func (t *coverTrainer) bestSegment(dmers []uint64, freq map[uint64]int, from, to int) coverSegment {
+	window := t.k - t.d + 1
+	active := make(map[uint64]int)
+	best := coverSegment{start: from, end: from}
+	score := 0
+
+	for i := from; i < to; i++ {
+		h := dmers[i]
+		if active[h] == 0 {
+			score += freq[h]
+		}
+		active[h]++
+
+		if i-from >= window {
+			old := dmers[i-window]
+			if active[old]--; active[old] == 0 {
+				score -= freq[old]
+				delete(active, old)
+			}
+		}
+
+		if score > best.score {
+			start := i - window + 1
+			if start < from {
+				start = from
+			}
+			best = coverSegment{start: start, end: i + 1, score: score}
+		}
+	}
+
+	return best
+}
+
+/* freqTrainer is the baseline: the most common fixed-length substrings, most common last */
+type freqTrainer struct {
+	length int
+	/* Percentage of the files a substring must appear in */
+	threshold float64
+	maxBytes  int
+}
+
+func (t *freqTrainer) String() string {
+	return "freq"
+}
+
+func (t *freqTrainer) params() map[string]string {
+	return map[string]string{"length": fmt.Sprint(t.length), "threshold": fmt.Sprint(t.threshold), "max_bytes": fmt.Sprint(t.maxBytes)}
+}
+
+func (t *freqTrainer) train(paths []string, size int) ([]byte, error) {
+	if t.length < 4 {
+		return nil, fmt.Errorf("invalid substring length %d: want at least 4", t.length)
+	}
+
+	samples, err := readSamples(paths, t.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		seen := make(map[string]bool)
+		for i := 0; i+t.length <= len(sample); i++ {
+			s := string(sample[i : i+t.length])
+			if !seen[s] {
+				seen[s] = true
+				counts[s]++
+			}
+		}
+	}
+
+	threshold := minSamples(len(samples), t.threshold)
+	common := make([]string, 0)
+	for s, n := range counts {
+		if n >= threshold {
+			common = append(common, s)
+		}
+	}
+	sort.Slice(common, func(i, j int) bool {
+		if counts[common[i]] != counts[common[j]] {
+			return counts[common[i]] > counts[common[j]]
+		}
+		return common[i] < common[j]
+	})
+
+	/* Substrings overlap a lot, skip the ones already picked */
+	picked := make([]byte, 0, size)
+	for _, s := range common {
+		if len(picked)+len(s) > size {
+			break
+		}
+		if !bytes.Contains(picked, []byte(s)) {
+			picked = append(picked, s...)
+		}
+	}
+
+	dict := make([]byte, 0, len(picked))
+	for i := len(picked) - t.length; i >= 0; i -= t.length {
+		dict = append(dict, picked[i:i+t.length]...)
+	}
+
+	return dict, nil
+}
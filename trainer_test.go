@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrainers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trainer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	page := func(i int) []byte {
+		return []byte(fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="utf-8"><title>Item %d</title>
+<link rel="stylesheet" href="/static/site.css"></head><body><div class="header"><a href="/">Home</a></div>
+<div class="content"><h1>Item number %d</h1><p>Price: %d.99</p></div><div class="footer">All rights reserved</div></body></html>`, i, i, i*7))
+	}
+
+	var paths []string
+	for i := 0; i < 50; i++ {
+		path := filepath.Join(dir, fmt.Sprint(i))
+		if err := ioutil.WriteFile(path, page(i), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	in := page(1000)
+	c := &gzipper{}
+	plain := len(c.CompressWithDict(in, nil, 6))
+
+	for _, tr := range []trainer{&coverTrainer{k: 64, d: 8}, &freqTrainer{length: 8, threshold: 10}} {
+		dict, err := tr.train(paths, 1024)
+		if err != nil {
+			t.Fatal(tr, err)
+		}
+		if len(dict) == 0 || len(dict) > 1024 {
+			t.Fatalf("%s: dictionary of %d bytes, want 1 to 1024", tr, len(dict))
+		}
+		if n := len(c.CompressWithDict(in, dict, 6)); n >= plain {
+			t.Errorf("%s: %d bytes with the dictionary, %d without", tr, n, plain)
+		}
+	}
+}
+
+func TestSamplePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trainer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for i := 0; i < 10; i++ {
+		path := filepath.Join(dir, fmt.Sprint(i))
+		if err := ioutil.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	for _, c := range []struct {
+		max  int
+		want string
+	}{{0, "0123456789"}, {1000, "0123456789"}, {350, "036"}, {550, "02468"}, {750, "0123468"}} {
+		picked, err := samplePaths(paths, c.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, p := range picked {
+			got += filepath.Base(p)
+		}
+		if got != c.want {
+			t.Errorf("At most %d bytes: picked %s, want %s", c.max, got, c.want)
+		}
+	}
+}
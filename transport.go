@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+   Compression Dictionary Transport (RFC 9842): a response carrying a Use-As-Dictionary header
+   becomes the dictionary of later same-origin requests its match pattern covers, which are
+   then sent as dcb (Brotli) or dcz (zstd), with a header naming the dictionary by its SHA-256.
+*/
+
+var dcbMagic = []byte{0xff, 0x44, 0x43, 0x42}
+
+/* dcz wraps the hash in a zstd skippable frame: its magic, then the frame length, 32 */
+var dczMagic = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+
+var errTransportHeader = errors.New("invalid dictionary transport header")
+
+/* transportHeader is the magic and the SHA-256 of the dictionary, in front of every dcb (36 bytes) and dcz (40 bytes) response */
+func transportHeader(magic, dict []byte) []byte {
+	sum := sha256.Sum256(dict)
+	return append(append(make([]byte, 0, len(magic)+sha256.Size), magic...), sum[:]...)
+}
+
+/* checkTransportHeader strips the header of in, after checking it names dict */
+func checkTransportHeader(in, magic, dict []byte) ([]byte, error) {
+	header := transportHeader(magic, dict)
+	if !bytes.HasPrefix(in, header) {
+		return nil, errTransportHeader
+	}
+	return in[len(header):], nil
+}
+
+/* dcber is Brotli as a dcb content encoding: without a dictionary it is plain br */
+type dcber struct {
+	brotler
+}
+
+func (c *dcber) String() string {
+	return "DCB"
+}
+
+func (c *dcber) CompressWithDict(in, dict []byte, quality int) []byte {
+	if dict == nil {
+		return c.brotler.CompressWithDict(in, nil, quality)
+	}
+	return append(transportHeader(dcbMagic, dict), c.brotler.CompressWithDict(in, dict, quality)...)
+}
+
+func (c *dcber) DecompressWithDict(in, dict []byte) ([]byte, error) {
+	if dict == nil {
+		return c.brotler.DecompressWithDict(in, nil)
+	}
+	in, err := checkTransportHeader(in, dcbMagic, dict)
+	if err != nil {
+		return nil, err
+	}
+	return c.brotler.DecompressWithDict(in, dict)
+}
+
+/* dczer is zstd as a dcz content encoding: the dictionary is always raw content, without a dictionary it is plain zstd */
+type dczer struct {
+	zstder
+}
+
+func (c *dczer) String() string {
+	return "DCZ"
+}
+
+func (c *dczer) CompressWithDict(in, dict []byte, quality int) []byte {
+	if dict == nil {
+		return zstdCompress(in, nil, false, quality)
+	}
+	return append(transportHeader(dczMagic, dict), zstdCompress(in, dict, true, quality)...)
+}
+
+func (c *dczer) LoadDictTime(dict []byte, quality int) time.Duration {
+	return zstdLoadDictTime(dict, true, quality)
+}
+
+func (c *dczer) DecompressWithDict(in, dict []byte) ([]byte, error) {
+	if dict == nil {
+		return zstdDecompress(in, nil, false)
+	}
+	in, err := checkTransportHeader(in, dczMagic, dict)
+	if err != nil {
+		return nil, err
+	}
+	return zstdDecompress(in, dict, true)
+}
+
+/* transportDict is a response that advertised itself with Use-As-Dictionary */
+type transportDict struct {
+	origin  string
+	match   *regexp.Regexp
+	pattern string
+	dests   []string
+	content []byte
+}
+
+/* parseUseAsDictionary reads the structured field dictionary of a Use-As-Dictionary header */
+func parseUseAsDictionary(value string) map[string][]string {
+	params := make(map[string][]string)
+
+	for _, member := range splitOutside(value, ',') {
+		kv := strings.SplitN(member, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if key == "" {
+			continue
+		}
+		if len(kv) == 1 {
+			params[key] = []string{"?1"}
+			continue
+		}
+
+		v := strings.TrimSpace(kv[1])
+		if strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")") {
+			list := make([]string, 0)
+			for _, item := range splitOutside(v[1:len(v)-1], ' ') {
+				list = append(list, strings.Trim(item, "\""))
+			}
+			params[key] = list
+		} else {
+			params[key] = []string{strings.Trim(v, "\"")}
+		}
+	}
+
+	return params
+}
+
+/* splitOutside splits s on sep, except inside quotes and parentheses */
+func splitOutside(s string, sep byte) []string {
+	ret := make([]string, 0)
+	quoted, depth, start := false, 0, 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == '(' && !quoted:
+			depth++
+		case s[i] == ')' && !quoted:
+			depth--
+		case s[i] == sep && !quoted && depth == 0:
+			if part := strings.TrimSpace(s[start:i]); part != "" {
+				ret = append(ret, part)
+			}
+			start = i + 1
+		}
+	}
+	if part := strings.TrimSpace(s[start:]); part != "" {
+		ret = append(ret, part)
+	}
+
+	return ret
+}
+
+/* Wildcards and named groups of a URL pattern, once escaped */
+var matchToken = regexp.MustCompile(`%2A|:[A-Za-z_][A-Za-z0-9_]*`)
+
+/* compileMatch turns the pathname URL pattern of match into a regexp; patterns with regexp groups are not supported, like in browsers */
+func compileMatch(base *url.URL, match string) (*regexp.Regexp, error) {
+	if strings.ContainsAny(match, "(){}") {
+		return nil, errors.New("unsupported match pattern " + match)
+	}
+
+	ref, err := url.Parse(strings.Replace(match, "*", "%2A", -1))
+	if err != nil {
+		return nil, err
+	}
+	u := base.ResolveReference(ref)
+	if origin(u) != origin(base) {
+		return nil, errors.New("cross-origin match pattern " + match)
+	}
+
+	pattern := matchToken.ReplaceAllStringFunc(regexp.QuoteMeta(u.EscapedPath()), func(token string) string {
+		if token == "%2A" {
+			return ".*"
+		}
+		return "[^/]+"
+	})
+	return regexp.Compile("^" + pattern + "$")
+}
+
+func origin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+/* requestDest guesses the request destination match-dest is compared with, from the content type */
+func requestDest(contentType string) string {
+	switch {
+	case contentType == "text/html" || contentType == "application/xhtml+xml":
+		return "document"
+	case contentType == "text/css":
+		return "style"
+	case strings.Contains(contentType, "javascript"):
+		return "script"
+	case strings.HasPrefix(contentType, "font/") || strings.Contains(contentType, "font"):
+		return "font"
+	case strings.HasPrefix(contentType, "image/"):
+		return "image"
+	}
+	return "empty"
+}
+
+func assetURL(u *asset) string {
+	if u.info != nil && u.info.FinalURL != "" {
+		return u.info.FinalURL
+	}
+	return u.path
+}
+
+/* newTransportDict returns the dictionary u advertises, or assumes with -dict-match, nil if there is none */
+func newTransportDict(u *asset) *transportDict {
+	var value string
+	if u.info != nil {
+		value = u.info.header("Use-As-Dictionary")
+	}
+	if value == "" && *assumeDictMatch != "" {
+		value = "match=\"" + *assumeDictMatch + "\""
+	}
+	if value == "" || len(u.content) == 0 {
+		return nil
+	}
+
+	params := parseUseAsDictionary(value)
+	match := params["match"]
+	if len(match) != 1 {
+		return nil
+	}
+	if t, ok := params["type"]; ok && (len(t) != 1 || t[0] != "raw") {
+		return nil
+	}
+
+	base, err := url.Parse(assetURL(u))
+	if err != nil || !base.IsAbs() {
+		return nil
+	}
+	re, err := compileMatch(base, match[0])
+	if err != nil {
+		return nil
+	}
+
+	return &transportDict{origin: origin(base), match: re, pattern: match[0], dests: params["match-dest"], content: u.content}
+}
+
+func (d *transportDict) matches(target *url.URL, dest string) bool {
+	if origin(target) != d.origin || !d.match.MatchString(target.EscapedPath()) {
+		return false
+	}
+	if len(d.dests) == 0 {
+		return true
+	}
+	for _, allowed := range d.dests {
+		if allowed == dest {
+			return true
+		}
+	}
+	return false
+}
+
+/* Use the most specific dictionary an earlier response advertised for this URL, the most recent one on a tie; dictionaries are whole responses */
+func strategyTransport(c compressor, size int) dictSelector {
+	dicts := make([]*transportDict, 0)
+
+	return func(u *asset) ([]byte, string) {
+		var best *transportDict
+
+		if target, err := url.Parse(assetURL(u)); err == nil {
+			for _, d := range dicts {
+				if d.matches(target, requestDest(u.contentType)) && (best == nil || len(d.pattern) >= len(best.pattern)) {
+					best = d
+				}
+			}
+		}
+
+		if d := newTransportDict(u); d != nil {
+			dicts = append(dicts, d)
+		}
+
+		if best == nil {
+			return nil, "none"
+		}
+		return best.content, "transport"
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseUseAsDictionary(t *testing.T) {
+	params := parseUseAsDictionary(`match="/js/app.*.js", match-dest=("script" "style"), id="dict-1, v2", type=raw`)
+
+	if m := params["match"]; len(m) != 1 || m[0] != "/js/app.*.js" {
+		t.Errorf("match = %q", m)
+	}
+	if d := params["match-dest"]; len(d) != 2 || d[0] != "script" || d[1] != "style" {
+		t.Errorf("match-dest = %q", d)
+	}
+	if id := params["id"]; len(id) != 1 || id[0] != "dict-1, v2" {
+		t.Errorf("id = %q", id)
+	}
+	if ty := params["type"]; len(ty) != 1 || ty[0] != "raw" {
+		t.Errorf("type = %q", ty)
+	}
+}
+
+func TestCompileMatch(t *testing.T) {
+	base, _ := url.Parse("https://example.com/js/app.v1.js")
+
+	tests := []struct {
+		match, path string
+		want        bool
+	}{
+		{"/js/app.*.js", "/js/app.v2.js", true},
+		{"/js/app.*.js", "/js/vendor.v2.js", false},
+		{"app.*.js", "/js/app.v3.js", true},
+		{"/product/:id/page", "/product/42/page", true},
+		{"/product/:id/page", "/product/42/7/page", false},
+		{"/*", "/anything/at/all", true},
+	}
+
+	for _, test := range tests {
+		re, err := compileMatch(base, test.match)
+		if err != nil {
+			t.Fatal(test.match, err)
+		}
+		if got := re.MatchString(test.path); got != test.want {
+			t.Errorf("%s on %s: got %v, want %v", test.match, test.path, got, test.want)
+		}
+	}
+
+	for _, match := range []string{"https://other.com/*", "/(\\d+)/*"} {
+		if _, err := compileMatch(base, match); err == nil {
+			t.Errorf("%s: expected an error", match)
+		}
+	}
+}
+
+func TestTransportEncodings(t *testing.T) {
+	dict := []byte(strings.Repeat("function hello(world) { return world; }\n", 20))
+	in := []byte(strings.Repeat("function hello(world) { return world + 1; }\n", 20))
+	sum := sha256.Sum256(dict)
+
+	tests := []struct {
+		c      compressor
+		header []byte
+	}{
+		{&dcber{}, []byte{0xff, 0x44, 0x43, 0x42}},
+		{&dczer{}, []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}},
+	}
+
+	for _, test := range tests {
+		c := test.c
+		out := c.CompressWithDict(in, dict, 5)
+		n := len(test.header)
+		if !bytes.Equal(out[:n], test.header) || !bytes.Equal(out[n:n+32], sum[:]) {
+			t.Errorf("%s: header does not carry the SHA-256 of the dictionary", c)
+		}
+
+		dec, err := c.DecompressWithDict(out, dict)
+		if err != nil || !bytes.Equal(dec, in) {
+			t.Errorf("%s: round trip failed: %v", c, err)
+		}
+		if _, ok := c.(*dczer); ok {
+			/* The header is a skippable frame, a zstd decoder reads dcz as is */
+			if dec, err := zstdDecompress(out, dict, true); err != nil || !bytes.Equal(dec, in) {
+				t.Errorf("%s: zstd can not skip the header: %v", c, err)
+			}
+		}
+		if _, err := c.DecompressWithDict(out, in); err != errTransportHeader {
+			t.Errorf("%s: wrong dictionary accepted: %v", c, err)
+		}
+
+		plain := c.CompressWithDict(in, nil, 5)
+		if dec, err := c.DecompressWithDict(plain, nil); err != nil || !bytes.Equal(dec, in) {
+			t.Errorf("%s: round trip without dictionary failed: %v", c, err)
+		}
+	}
+}
+
+func TestStrategyTransport(t *testing.T) {
+	advertise := func(match string) *manifestEntry {
+		return &manifestEntry{Headers: map[string][]string{"use-as-dictionary": {match}}}
+	}
+	list := []*asset{
+		{path: "https://example.com/", contentType: "text/html", content: []byte("page"), info: &manifestEntry{}},
+		{path: "https://example.com/js/app.v1.js", contentType: "application/javascript", content: []byte("app v1"), info: advertise(`match="/js/*", match-dest=("script")`)},
+		{path: "https://example.com/js/lib.v1.js", contentType: "application/javascript", content: []byte("lib v1"), info: advertise(`match="/js/lib.*.js"`)},
+		{path: "https://example.com/js/app.v2.js", contentType: "application/javascript", content: []byte("app v2"), info: &manifestEntry{}},
+		{path: "https://example.com/js/lib.v2.js", contentType: "application/javascript", content: []byte("lib v2"), info: &manifestEntry{}},
+		{path: "https://example.com/js/data.json", contentType: "application/json", content: []byte("{}"), info: &manifestEntry{}},
+		{path: "https://cdn.example.com/js/app.v2.js", contentType: "application/javascript", content: []byte("app v2"), info: &manifestEntry{}},
+	}
+	want := []string{"", "", "app v1", "app v1", "lib v1", "", ""}
+
+	selectDict := strategyTransport(&dcber{}, dictSize)
+	for i, u := range list {
+		dict, source := selectDict(u)
+		if string(dict) != want[i] {
+			t.Errorf("%s: got dictionary %q from %s, want %q", u.path, dict, source, want[i])
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type rankedSite struct {
+	rank    int
+	address string
+}
+
+/* urlSource provides the list of sites to crawl, in rank order */
+type urlSource interface {
+	String() string
+	sites() ([]rankedSite, error)
+}
+
+/* listSource reads a site list from a file, a URL or stdin ("-"), zipped or gzipped */
+type listSource struct {
+	location string
+	format   string
+}
+
+/* staticSource is a fixed list of sites, e.g. from -w */
+type staticSource []string
+
+/* sitemapSource crawls every page listed in a sitemap.xml */
+type sitemapSource struct {
+	location string
+}
+
+func (s *listSource) String() string {
+	return s.location
+}
+
+func (s staticSource) String() string {
+	return strings.Join(s, ",")
+}
+
+func (s *sitemapSource) String() string {
+	return s.location
+}
+
+func (s staticSource) sites() ([]rankedSite, error) {
+	ret := make([]rankedSite, len(s))
+	for i, site := range s {
+		ret[i] = rankedSite{rank: i + 1, address: site}
+	}
+	return ret, nil
+}
+
+type readerAt struct {
+	body []byte
+}
+
+func (r readerAt) ReadAt(p []byte, off int64) (n int, err error) {
+	n = copy(p, r.body[off:])
+
+	if n < len(p) {
+		err = fmt.Errorf("end of buffer")
+	}
+	return
+}
+
+/* openList returns the uncompressed content of a file, URL or stdin */
+func openList(location string) ([]byte, error) {
+	var body []byte
+	var err error
+
+	switch {
+	case location == "-":
+		body, err = ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://"):
+		res, e := http.Get(location)
+		if e != nil {
+			return nil, e
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", location, res.Status)
+		}
+		body, err = ioutil.ReadAll(res.Body)
+	default:
+		body, err = ioutil.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	/* Sniff the compression rather than trusting the name, lists are often served as octet-stream */
+	switch {
+	case bytes.HasPrefix(body, []byte("PK\x03\x04")):
+		r, err := zip.NewReader(readerAt{body}, int64(len(body)))
+		if err != nil {
+			return nil, err
+		}
+		if len(r.File) == 0 {
+			return nil, fmt.Errorf("%s: empty zip file", location)
+		}
+		rc, err := r.File[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	case bytes.HasPrefix(body, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(gz)
+	}
+
+	return body, nil
+}
+
+/* trimAddress turns a list entry into the address form download expects: no scheme, no trailing slash */
+func trimAddress(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "http://"), "https://")
+	return strings.TrimSuffix(s, "/")
+}
+
+func (s *listSource) sites() ([]rankedSite, error) {
+	/*
+	   Supported formats:
+	   plain    - one site per line, ranked by line number (isthewebhttp2yet)
+	   csv      - rank,site (Alexa, Tranco, Cisco Umbrella)
+	   majestic - GlobalRank,TldRank,Domain,... with a header row
+	   auto     - guess from the first line
+	*/
+	body, err := openList(s.location)
+	if err != nil {
+		return nil, err
+	}
+
+	format := s.format
+	ret := make([]rankedSite, 0)
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	line := 0
+
+	for sc.Scan() {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		line++
+
+		if format == "" || format == "auto" {
+			switch {
+			case strings.HasPrefix(text, "GlobalRank"):
+				format = "majestic"
+			case strings.Contains(text, ","):
+				format = "csv"
+			default:
+				format = "plain"
+			}
+		}
+
+		rank, address := line, text
+		switch format {
+		case "plain":
+		case "csv", "majestic":
+			col := 1
+			if format == "majestic" {
+				col = 2
+			}
+			fields := strings.Split(text, ",")
+			r, err := strconv.Atoi(fields[0])
+			if err != nil || len(fields) <= col {
+				/* Header row */
+				continue
+			}
+			rank, address = r, fields[col]
+		default:
+			return nil, fmt.Errorf("unknown list format %q", format)
+		}
+
+		if address = trimAddress(address); address != "" {
+			ret = append(ret, rankedSite{rank: rank, address: address})
+		}
+	}
+
+	return ret, sc.Err()
+}
+
+type sitemapXML struct {
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+/* Sitemap indexes are followed one level deep */
+func (s *sitemapSource) sites() ([]rankedSite, error) {
+	ret := make([]rankedSite, 0)
+
+	body, err := openList(s.location)
+	if err != nil {
+		return nil, err
+	}
+
+	var sm sitemapXML
+	if err := xml.Unmarshal(body, &sm); err != nil {
+		return nil, err
+	}
+
+	locs := sm.URLs
+	for _, child := range sm.Sitemaps {
+		body, err := openList(strings.TrimSpace(child))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		var csm sitemapXML
+		if err := xml.Unmarshal(body, &csm); err != nil {
+			log.Println(child, err)
+			continue
+		}
+		locs = append(locs, csm.URLs...)
+	}
+
+	for _, loc := range locs {
+		if address := trimAddress(loc); address != "" {
+			ret = append(ret, rankedSite{rank: len(ret) + 1, address: address})
+		}
+	}
+
+	return ret, nil
+}
+
+func newURLSource(location, format string) urlSource {
+	if format == "sitemap" {
+		return &sitemapSource{location: location}
+	}
+	return &listSource{location: location, format: format}
+}
+
+/* Stratified sampling buckets sites by order of magnitude of their rank: 1-9, 10-99, 100-999, ... */
+func rankBucket(rank int) int {
+	if rank < 1 {
+		return 0
+	}
+	return int(math.Log10(float64(rank)))
+}
+
+/* sampleSites picks n sites after skipping the first start ones, deterministically for a given seed */
+func sampleSites(list []rankedSite, mode string, start, n int, seed int64) ([]string, error) {
+	sort.SliceStable(list, func(i, j int) bool { return list[i].rank < list[j].rank })
+
+	if start > len(list) {
+		start = len(list)
+	}
+	list = list[start:]
+
+	rnd := rand.New(rand.NewSource(seed))
+	picked := make([]rankedSite, 0, n)
+
+	switch mode {
+	case "top":
+		if n < len(list) {
+			list = list[:n]
+		}
+		picked = list
+	case "random":
+		for _, i := range rnd.Perm(len(list)) {
+			if len(picked) == n {
+				break
+			}
+			picked = append(picked, list[i])
+		}
+	case "stratified":
+		buckets := make(map[int][]rankedSite)
+		keys := make([]int, 0)
+		for _, s := range list {
+			b := rankBucket(s.rank)
+			if _, ok := buckets[b]; !ok {
+				keys = append(keys, b)
+			}
+			buckets[b] = append(buckets[b], s)
+		}
+		/* Smallest buckets first, so the quota they can't fill goes to the bigger ones */
+		sort.Ints(keys)
+		sort.SliceStable(keys, func(i, j int) bool { return len(buckets[keys[i]]) < len(buckets[keys[j]]) })
+
+		remaining := n
+		for i, b := range keys {
+			left := len(keys) - i
+			quota := remaining / left
+			if remaining%left != 0 {
+				quota++
+			}
+			bucket := buckets[b]
+			for j, k := range rnd.Perm(len(bucket)) {
+				if j == quota {
+					break
+				}
+				picked = append(picked, bucket[k])
+				remaining--
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown sampling mode %q", mode)
+	}
+
+	/* Crawl in rank order no matter how the sites were picked */
+	sort.SliceStable(picked, func(i, j int) bool { return picked[i].rank < picked[j].rank })
+
+	seen := make(map[string]bool)
+	ret := make([]string, 0, len(picked))
+	for _, s := range picked {
+		if !seen[s.address] {
+			seen[s.address] = true
+			ret = append(ret, s.address)
+		}
+	}
+
+	return ret, nil
+}
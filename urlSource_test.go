@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestListSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tranco := "1,google.com\n2,youtube.com\n3,facebook.com\n"
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, _ := zw.Create("top-1m.csv")
+	w.Write([]byte(tranco))
+	zw.Close()
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte(tranco))
+	gw.Close()
+
+	want := []rankedSite{{1, "google.com"}, {2, "youtube.com"}, {3, "facebook.com"}}
+	tests := []struct {
+		name, format, content string
+		want                  []rankedSite
+	}{
+		{"plain", "auto", "# comment\nhttps://google.com/\n\nyoutube.com\nfacebook.com\n", want},
+		{"tranco", "auto", tranco, want},
+		{"header", "csv", "rank,domain\n" + tranco, want},
+		{"umbrella", "csv", "1,google.com\n2,youtube.com\n3,facebook.com", want},
+		{"majestic", "auto", "GlobalRank,TldRank,Domain,TLD,RefSubNets\n1,1,google.com,com,1\n2,2,youtube.com,com,1\n3,3,facebook.com,com,1\n", want},
+		{"explicit-majestic", "majestic", "1,1,google.com,com,1\n", want[:1]},
+		{"zip", "auto", zipped.String(), want},
+		{"gzip", "csv", gzipped.String(), want},
+		{"sitemap", "sitemap", `<?xml version="1.0"?><urlset><url><loc>https://example.com/a/</loc></url><url><loc>https://example.com/b</loc></url></urlset>`,
+			[]rankedSite{{1, "example.com/a"}, {2, "example.com/b"}}},
+	}
+
+	for _, test := range tests {
+		path := dir + "/" + test.name
+		if err := ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := newURLSource(path, test.format).sites()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := newURLSource(dir+"/plain", "xml").sites(); err == nil {
+		t.Errorf("Unknown format should fail")
+	}
+}
+
+func TestSampleSites(t *testing.T) {
+	list := func() []rankedSite {
+		ret := make([]rankedSite, 100000)
+		for i := range ret {
+			ret[i] = rankedSite{rank: len(ret) - i, address: fmt.Sprintf("site%d.com", len(ret)-i)}
+		}
+		return ret
+	}
+
+	for _, mode := range []string{"top", "random", "stratified"} {
+		for _, n := range []int{5, 200, 1000} {
+			a, err := sampleSites(list(), mode, 10, n, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := sampleSites(list(), mode, 10, n, 1)
+			if len(a) != n {
+				t.Errorf("%s %d: got %d sites", mode, n, len(a))
+			}
+			if !reflect.DeepEqual(a, b) {
+				t.Errorf("%s %d: same seed, different sites", mode, n)
+			}
+		}
+	}
+
+	top, _ := sampleSites(list(), "top", 10, 3, 1)
+	if !reflect.DeepEqual(top, []string{"site11.com", "site12.com", "site13.com"}) {
+		t.Errorf("Unexpected top sites %v", top)
+	}
+
+	a, _ := sampleSites(list(), "random", 0, 200, 1)
+	b, _ := sampleSites(list(), "random", 0, 200, 2)
+	if reflect.DeepEqual(a, b) {
+		t.Errorf("Different seeds, same sites")
+	}
+
+	/* A short list gives all it has */
+	short, _ := sampleSites(list()[99990:], "stratified", 0, 200, 1)
+	if len(short) != 10 {
+		t.Errorf("Expected the whole list, got %d sites", len(short))
+	}
+
+	if _, err := sampleSites(list(), "best", 0, 1, 1); err == nil {
+		t.Errorf("Unknown mode should fail")
+	}
+}
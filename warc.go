@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"./bro"
+)
+
+const warcVersion = "WARC/1.1"
+
+type warcRecord struct {
+	header textproto.MIMEHeader
+	block  []byte
+}
+
+/* readWARCRecord reads the next record, returns io.EOF once the archive is exhausted */
+func readWARCRecord(r *bufio.Reader) (*warcRecord, error) {
+	var version string
+	for version == "" {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(line) == "" {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		version = strings.TrimSpace(line)
+	}
+
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("warc: invalid record start %q", version)
+	}
+
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("warc: invalid Content-Length: %v", err)
+	}
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return nil, err
+	}
+
+	return &warcRecord{header: header, block: block}, nil
+}
+
+func writeWARCRecord(w io.Writer, gzipped bool, header [][2]string, block []byte) error {
+	var b bytes.Buffer
+
+	b.WriteString(warcVersion + "\r\n")
+	for _, h := range header {
+		b.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	b.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+	b.Write(block)
+	b.WriteString("\r\n\r\n")
+
+	/* Each record is a separate gzip member, as is customary for .warc.gz */
+	if gzipped {
+		gz := gzip.NewWriter(w)
+		if _, err := gz.Write(b.Bytes()); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func warcRecordID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+func warcDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+/* decodeContent undoes the Content-Encoding, dataset files always hold the identity encoding */
+func decodeContent(body []byte, encoding string) ([]byte, error) {
+	var r io.Reader
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	case "deflate":
+		r = flate.NewReader(bytes.NewReader(body))
+	case "br":
+		br, err := bro.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer br.Close()
+		r = br
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+/* validSiteName keeps site names from archives made by others inside the dataset directory */
+func validSiteName(name string) bool {
+	return name != "" && name != "." && !strings.ContainsAny(name, "/\\") && !strings.Contains(name, "..")
+}
+
+type warcSite struct {
+	path string
+	man  *manifest
+}
+
+/* Records are grouped by the site named in their warcinfo record (see exportWARC), otherwise by host */
+func importWARC(warcPath string) {
+	log.Print(warcPath)
+
+	f, err := os.Open(warcPath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+
+	var in io.Reader = f
+	if strings.HasSuffix(warcPath, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		in = gz
+	}
+	r := bufio.NewReader(in)
+
+	infoSites := make(map[string]string)
+	sites := make(map[string]*warcSite)
+	order := make([]string, 0)
+
+	for {
+		rec, err := readWARCRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println("Invalid WARC record", err)
+			break
+		}
+
+		switch rec.header.Get("WARC-Type") {
+		case "warcinfo":
+			fields, err := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(rec.block), strings.NewReader("\r\n\r\n")))).ReadMIMEHeader()
+			if site := fields.Get("Site"); err == nil && site != "" {
+				if validSiteName(site) {
+					infoSites[rec.header.Get("WARC-Record-ID")] = site
+				} else {
+					log.Println("Invalid site name", site, "in", warcPath)
+				}
+			}
+			continue
+		case "response":
+		default:
+			continue
+		}
+
+		targetURI := strings.Trim(rec.header.Get("WARC-Target-URI"), "<>")
+		u, err := url.Parse(targetURI)
+		if err != nil || u.Host == "" {
+			continue
+		}
+
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rec.block)), &http.Request{Method: "GET", URL: u})
+		if err != nil {
+			log.Println(targetURI, err)
+			continue
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			log.Println(targetURI, err)
+			continue
+		}
+
+		/* Error pages and redirects are not what the site serves */
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			continue
+		}
+
+		contentType := res.Header.Get("Content-Type")
+		if !mayAccept(contentType) {
+			continue
+		}
+
+		body, err = decodeContent(body, res.Header.Get("Content-Encoding"))
+		if err != nil {
+			log.Println(targetURI, err)
+			continue
+		}
+
+		if len(body) == 0 || !acceptAsset(contentType, body) {
+			continue
+		}
+
+		name, ok := infoSites[rec.header.Get("WARC-Warcinfo-ID")]
+		if !ok {
+			name = u.Hostname()
+		}
+		if !validSiteName(name) {
+			log.Println("Invalid site name", name, "for", targetURI)
+			continue
+		}
+
+		site, ok := sites[name]
+		if !ok {
+			site = &warcSite{path: datapath + name, man: &manifest{Site: targetURI}}
+			if err := os.MkdirAll(site.path, 0777); err != nil {
+				log.Println("Could not create dir", err)
+				continue
+			}
+			sites[name] = site
+			order = append(order, name)
+		}
+
+		err = ioutil.WriteFile(site.path+"/"+strconv.Itoa(len(site.man.Assets)), body, 0777)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		entry := newManifestEntry(targetURI, contentType, body)
+		entry.FinalURL = targetURI
+		entry.Status = res.StatusCode
+		entry.ContentEncoding = res.Header.Get("Content-Encoding")
+		entry.Headers = res.Header
+		if date, err := time.Parse(time.RFC3339Nano, rec.header.Get("WARC-Date")); err == nil {
+			entry.FetchTime = date.UTC()
+		}
+		site.man.Assets = append(site.man.Assets, entry)
+	}
+
+	for _, name := range order {
+		log.Println(name, len(sites[name].man.Assets))
+		if err := writeManifest(sites[name].path+"/manifest", sites[name].man); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func importWARCs(paths []string) {
+	for _, p := range paths {
+		if p != "" {
+			importWARC(p)
+		}
+	}
+}
+
+/* Hop-by-hop and encoding headers no longer describe the stored identity body */
+var warcSkipHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+func warcResponseBlock(e *manifestEntry, body []byte) []byte {
+	var b bytes.Buffer
+
+	status := e.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+
+	names := make([]string, 0, len(e.Headers))
+	for k := range e.Headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	hasType := false
+	for _, k := range names {
+		ck := textproto.CanonicalMIMEHeaderKey(k)
+		if warcSkipHeaders[ck] {
+			continue
+		}
+		if ck == "Content-Type" {
+			hasType = true
+		}
+		for _, v := range e.Headers[k] {
+			b.WriteString(ck + ": " + v + "\r\n")
+		}
+	}
+	if !hasType {
+		b.WriteString("Content-Type: " + e.ContentType + "\r\n")
+	}
+	b.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n")
+	b.Write(body)
+
+	return b.Bytes()
+}
+
+func warcRequestBlock(u *url.URL) []byte {
+	return []byte("GET " + u.RequestURI() + " HTTP/1.1\r\nHost: " + u.Host + "\r\n\r\n")
+}
+
+/* exportWARC writes the whole dataset as WARC/1.1 request and response records */
+func exportWARC(warcPath string) {
+	f, err := os.Create(warcPath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+	gzipped := strings.HasSuffix(warcPath, ".gz")
+
+	for _, d := range siteDirs() {
+		man, err := readManifest(datapath + d.Name() + "/manifest")
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		infoID := warcRecordID()
+		info := []byte("software: dict_crawler\r\nformat: WARC File Format 1.1\r\nsite: " + d.Name() + "\r\n")
+		err = writeWARCRecord(w, gzipped, [][2]string{
+			{"WARC-Type", "warcinfo"},
+			{"WARC-Record-ID", infoID},
+			{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+			{"Content-Type", "application/warc-fields"},
+		}, info)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		for idx, e := range man.Assets {
+			body, err := ioutil.ReadFile(datapath + d.Name() + "/" + strconv.Itoa(idx))
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			u, err := url.Parse(e.URL)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			date := e.FetchTime
+			if date.IsZero() {
+				date = time.Now()
+			}
+			responseID := warcRecordID()
+			response := warcResponseBlock(e, body)
+
+			err = writeWARCRecord(w, gzipped, [][2]string{
+				{"WARC-Type", "response"},
+				{"WARC-Record-ID", responseID},
+				{"WARC-Warcinfo-ID", infoID},
+				{"WARC-Date", date.UTC().Format(time.RFC3339)},
+				{"WARC-Target-URI", e.URL},
+				{"WARC-Block-Digest", warcDigest(response)},
+				{"WARC-Payload-Digest", warcDigest(body)},
+				{"Content-Type", "application/http;msgtype=response"},
+			}, response)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			err = writeWARCRecord(w, gzipped, [][2]string{
+				{"WARC-Type", "request"},
+				{"WARC-Record-ID", warcRecordID()},
+				{"WARC-Warcinfo-ID", infoID},
+				{"WARC-Concurrent-To", responseID},
+				{"WARC-Date", date.UTC().Format(time.RFC3339)},
+				{"WARC-Target-URI", e.URL},
+				{"Content-Type", "application/http;msgtype=request"},
+			}, warcRequestBlock(u))
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		}
+	}
+}
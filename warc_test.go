@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWARCRoundTrip(t *testing.T) {
+	srv := fixtureServer()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer tempDataset(t)()
+	address := strings.TrimPrefix(srv.URL, "http://")
+	if err := downloadDataSet(newHTTPFetcher(), address, 1); err != nil {
+		t.Fatal(err)
+	}
+	want, err := readManifest(datapath + address + "/manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := datapath
+
+	for _, name := range []string{"dataset.warc", "dataset.warc.gz"} {
+		datapath = source
+		exportWARC(dir + "/" + name)
+
+		datapath = dir + "/" + name + ".import/"
+		importWARCs([]string{dir + "/" + name})
+
+		got, err := readManifest(datapath + address + "/manifest")
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if got.Site != want.Site || len(got.Assets) != len(want.Assets) {
+			t.Fatalf("%s: got %s with %d assets, want %s with %d", name, got.Site, len(got.Assets), want.Site, len(want.Assets))
+		}
+		for i, e := range got.Assets {
+			w := want.Assets[i]
+			if e.URL != w.URL || e.ContentType != w.ContentType || e.Status != w.Status || e.SHA256 != w.SHA256 || e.Length != w.Length {
+				t.Errorf("%s asset %d: got %+v, want %+v", name, i, e, w)
+			}
+		}
+	}
+}
+
+func TestWARCImportSiteName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := datapath
+	datapath = dir + "/dataset/"
+	defer func() { datapath = old }()
+
+	var b bytes.Buffer
+	writeWARCRecord(&b, false, [][2]string{{"WARC-Type", "warcinfo"}, {"WARC-Record-ID", "<urn:uuid:1>"}}, []byte("site: ../evil\r\n"))
+	writeWARCRecord(&b, false, [][2]string{{"WARC-Type", "response"}, {"WARC-Warcinfo-ID", "<urn:uuid:1>"}, {"WARC-Target-URI", "http://example.com/"}},
+		[]byte("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 13\r\n\r\n<html></html>"))
+	for _, status := range []string{"404 Not Found", "503 Service Unavailable", "302 Found"} {
+		writeWARCRecord(&b, false, [][2]string{{"WARC-Type", "response"}, {"WARC-Warcinfo-ID", "<urn:uuid:1>"}, {"WARC-Target-URI", "http://example.com/error"}},
+			[]byte("HTTP/1.1 "+status+"\r\nContent-Type: text/html\r\nContent-Length: 13\r\n\r\n<html></html>"))
+	}
+	if err := ioutil.WriteFile(dir+"/evil.warc", b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	importWARC(dir + "/evil.warc")
+	if _, err := os.Stat(dir + "/evil"); !os.IsNotExist(err) {
+		t.Errorf("Site name escaped the dataset directory")
+	}
+	if m, err := readManifest(datapath + "example.com/manifest"); err != nil || len(m.Assets) != 1 {
+		t.Errorf("Expected the asset under the host name, without the error pages: %v", err)
+	}
+}
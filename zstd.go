@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+/* zstder compresses with Zstandard, qualities are zstd levels, mapped to the four speeds of the Go encoder */
+type zstder struct {
+}
+
+/* zdictMagic starts every dictionary in the ZDICT format, anything else is used as raw content */
+const zdictMagic = 0xEC30A437
+
+func isZDict(dict []byte) bool {
+	return len(dict) >= 8 && binary.LittleEndian.Uint32(dict) == zdictMagic
+}
+
+func (c *zstder) String() string {
+	return "Zstd"
+}
+
+func (c *zstder) QualityRange() (int, int) {
+	return 1, 22
+}
+
+/* zstdDictOption loads dict as raw content if raw is set or it is not in the ZDICT format */
+func zstdDictOption(dict []byte, raw bool) zstd.EOption {
+	if !raw && isZDict(dict) {
+		return zstd.WithEncoderDict(dict)
+	}
+	return zstd.WithEncoderDictRaw(0, dict)
+}
+
+func zstdCompress(in, dict []byte, raw bool, quality int) []byte {
+	opts := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(quality)), zstd.WithEncoderConcurrency(1)}
+	if dict != nil {
+		opts = append(opts, zstdDictOption(dict, raw))
+	}
+
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil
+	}
+	defer enc.Close()
+
+	return enc.EncodeAll(in, nil)
+}
+
+func zstdLoadDictTime(dict []byte, raw bool, quality int) time.Duration {
+	start := time.Now()
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(quality)), zstd.WithEncoderConcurrency(1), zstdDictOption(dict, raw))
+	elapsed := time.Since(start)
+
+	if err == nil {
+		enc.Close()
+	}
+	return elapsed
+}
+
+func zstdDecompress(in, dict []byte, raw bool) ([]byte, error) {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if !raw && isZDict(dict) {
+		opts = append(opts, zstd.WithDecoderDicts(dict))
+	} else if dict != nil {
+		opts = append(opts, zstd.WithDecoderDictRaw(0, dict))
+	}
+
+	dec, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	return dec.DecodeAll(in, nil)
+}
+
+func (c *zstder) CompressWithDict(in, dict []byte, quality int) []byte {
+	return zstdCompress(in, dict, false, quality)
+}
+
+func (c *zstder) LoadDictTime(dict []byte, quality int) time.Duration {
+	return zstdLoadDictTime(dict, false, quality)
+}
+
+func (c *zstder) DecompressWithDict(in, dict []byte) ([]byte, error) {
+	return zstdDecompress(in, dict, false)
+}
+
+/* zstdDictID derives the dictionary ID from the content type, outside the ranges the format reserves */
+func zstdDictID(contentType string) uint32 {
+	const low, high = 1 << 15, 1 << 31
+	return low + crc32.ChecksumIEEE([]byte(contentType))%(high-low)
+}
+
+/* trainZstdDict builds a ZDICT format dictionary of at most size bytes from the samples */
+func trainZstdDict(contentType string, samples [][]byte, size int) ([]byte, error) {
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: size,
+		HashBytes:   6,
+		ZstdDictID:  zstdDictID(contentType),
+		ZstdLevel:   zstd.SpeedBestCompression,
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestZstdRoundTrip(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 200; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("<html><head><title>Page %d</title></head><body class=\"main\">%s</body></html>", i, strings.Repeat("x", i%17))))
+	}
+	trained, err := trainZstdDict("text/html", samples, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isZDict(trained) {
+		t.Fatal("trained dictionary is not in the ZDICT format")
+	}
+
+	in := []byte("<html><head><title>Page 1000</title></head><body class=\"main\">hello</body></html>")
+	c := &zstder{}
+
+	for _, dict := range [][]byte{nil, samples[0], trained} {
+		for _, quality := range []int{1, 3, 19} {
+			out := c.CompressWithDict(in, dict, quality)
+			dec, err := c.DecompressWithDict(out, dict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dec, in) {
+				t.Fatalf("quality %d, dictionary of %d bytes: round trip mismatch", quality, len(dict))
+			}
+		}
+	}
+
+	if len(c.CompressWithDict(in, trained, 3)) >= len(c.CompressWithDict(in, nil, 3)) {
+		t.Error("trained dictionary does not help")
+	}
+}